@@ -10,6 +10,7 @@ import (
 
 const sentryFlagKey = "sentry"
 const configFlagKey = "config"
+const identityFlagKey = "identity"
 
 var sentryIsInit bool
 
@@ -20,6 +21,12 @@ func main() {
 		Aliases: []string{"s"},
 		Usage:   "Sentry DSN",
 	}
+	identityFlag := &cli.StringFlag{
+		Name:      identityFlagKey,
+		Aliases:   []string{"i"},
+		Usage:     "path to the private key (age identity or OpenPGP key) to decrypt encrypted backups",
+		TakesFile: true,
+	}
 	configFlag := &cli.StringFlag{
 		Name:      configFlagKey,
 		Aliases:   []string{"c"},
@@ -43,7 +50,13 @@ func main() {
 				},
 				Action: func(ctx *cli.Context) error {
 					initSentry(ctx)
-					commands.Sync(ctx.String(configFlagKey))
+					if err := commands.Sync(ctx.String(configFlagKey)); err != nil {
+						// Exiting skips the flush at the end of main
+						if sentryIsInit {
+							sentry.Flush(5 * time.Second)
+						}
+						return cli.Exit(err.Error(), 1)
+					}
 
 					return nil
 				},
@@ -56,7 +69,180 @@ func main() {
 					configFlag,
 				},
 				Action: func(ctx *cli.Context) error {
-					panic("Not implemented yet")
+					if err := commands.TestConfig(ctx.String(configFlagKey)); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Usage:   "list all backups of the targets in the given config",
+				Flags: []cli.Flag{
+					configFlag,
+					&cli.StringFlag{
+						Name:    "target",
+						Aliases: []string{"t"},
+						Usage:   "only list the backups of this database or files backup",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the backups as JSON",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if err := commands.List(ctx.String(configFlagKey), ctx.String("target"), ctx.Bool("json")); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "verify",
+				Usage: "check the integrity of all backups of the targets in the given config",
+				Flags: []cli.Flag{
+					configFlag,
+					sentryFlag,
+					identityFlag,
+					&cli.StringFlag{
+						Name:    "target",
+						Aliases: []string{"t"},
+						Usage:   "only verify the backups of this database or files backup",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					initSentry(ctx)
+					if err := commands.Verify(ctx.String(configFlagKey), ctx.String("target"), ctx.String(identityFlagKey)); err != nil {
+						// Exiting skips the flush at the end of main
+						if sentryIsInit {
+							sentry.Flush(5 * time.Second)
+						}
+						return cli.Exit(err.Error(), 1)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "prune",
+				Usage: "apply the retention policy (interval, retention & quota) to the backups without making a new backup",
+				Flags: []cli.Flag{
+					configFlag,
+					&cli.StringFlag{
+						Name:    "target",
+						Aliases: []string{"t"},
+						Usage:   "only prune the backups of this database or files backup (skips the quota)",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "only print which files would be deleted or moved",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if err := commands.Prune(ctx.String(configFlagKey), ctx.String("target"), ctx.Bool("dry-run")); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "test-restore",
+				Usage: "load the latest backup of each MySQL/MariaDB database into a throwaway container & run a sanity query",
+				Flags: []cli.Flag{
+					configFlag,
+					sentryFlag,
+					identityFlag,
+					&cli.StringFlag{
+						Name:    "target",
+						Aliases: []string{"t"},
+						Usage:   "only test the backups of this database",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					initSentry(ctx)
+					if err := commands.TestRestore(ctx.String(configFlagKey), ctx.String("target"), ctx.String(identityFlagKey)); err != nil {
+						// Exiting skips the flush at the end of main
+						if sentryIsInit {
+							sentry.Flush(5 * time.Second)
+						}
+						return cli.Exit(err.Error(), 1)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "restore",
+				Usage: "restore a backup of the given target",
+				Flags: []cli.Flag{
+					configFlag,
+					identityFlag,
+					&cli.StringFlag{
+						Name:     "target",
+						Aliases:  []string{"t"},
+						Usage:    "name of the database or files backup to restore",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "date",
+						Usage: "date of the backup to restore (YYYY-MM-DD or YYYY-MM-DD_HH-MM for hourly backups)",
+					},
+					&cli.BoolFlag{
+						Name:  "latest",
+						Usage: "restore the latest backup",
+					},
+					&cli.StringFlag{
+						Name:  "database",
+						Usage: "restore into this database instead of the configured one",
+					},
+					&cli.StringFlag{
+						Name:  "container",
+						Usage: "restore into this container instead of the configured one",
+					},
+					&cli.StringFlag{
+						Name:      "to",
+						Usage:     "folder to extract a files backup into (default: ./[name]_[date])",
+						TakesFile: true,
+					},
+					&cli.StringSliceFlag{
+						Name:  "path",
+						Usage: "only extract this path or glob pattern from a files backup (can be repeated)",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "only list what would be extracted from a files backup",
+					},
+					&cli.BoolFlag{
+						Name:    "yes",
+						Aliases: []string{"y"},
+						Usage:   "don't ask for confirmation",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if ctx.IsSet("date") == ctx.Bool("latest") {
+						return cli.Exit("Either --date or --latest is required", 1)
+					}
+
+					err := commands.Restore(ctx.String(configFlagKey), commands.RestoreOptions{
+						Target:    ctx.String("target"),
+						Date:      ctx.String("date"),
+						Database:  ctx.String("database"),
+						Container: ctx.String("container"),
+						To:        ctx.String("to"),
+						Paths:     ctx.StringSlice("path"),
+						DryRun:    ctx.Bool("dry-run"),
+						Identity:  ctx.String(identityFlagKey),
+						Yes:       ctx.Bool("yes"),
+					})
+					if err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+
+					return nil
 				},
 			},
 		},
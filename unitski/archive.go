@@ -1,143 +1,295 @@
 package unitski
 
 import (
-	"log"
-	"os/exec"
+	"archive/tar"
+	"io"
+	"os"
 	"path/filepath"
-	"regexp"
-	"runtime"
-	"strconv"
 	"strings"
+	"syscall"
 )
 
-func matchFirstRegexGroupToInt(regex *regexp.Regexp, text string) (int64, error) {
-	// Should match '[size] [name of file]'
-	match := regex.FindStringSubmatch(text)
-	if match == nil {
-		return 0, &FileError{"du output did not match expected format: " + text}
-	}
+// tarBlockSize is the size of the blocks in a tar archive, every entry has a header block & its data is padded to a full block.
+const tarBlockSize = 512
 
-	// Convert to a i64
-	if result, err := strconv.ParseInt(match[1], 10, 64); err != nil {
-		return 0, err
-	} else {
-		return result, nil
+// GetFolderSize calculates the number of bytes the given file/folder would take in a tar archive, skipping excluded paths.
+// Files that can't be read are skipped, same as the archiver does. Hard linked files are only counted once.
+func GetFolderSize(folder string, exclude *ExcludeMatcher) int64 {
+	var size int64
+	seen := map[fileId]bool{}
+
+	_ = filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if exclude.Matches(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Every entry has a header
+		size += tarBlockSize
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		// Hard links are only stored once
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Nlink > 1 {
+			id := fileId{uint64(stat.Dev), uint64(stat.Ino)}
+			if seen[id] {
+				return nil
+			}
+			seen[id] = true
+		}
+
+		// The data is padded to full blocks
+		size += (info.Size() + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+
+		return nil
+	})
+
+	return size
+}
+
+// compressedSizeEstimate is the conservative fraction of the input size a compressed tar ball is assumed to take.
+// Already compressed data (images, archives, etc.) hardly shrinks, so this can't be too optimistic.
+const compressedSizeEstimate = 0.75
+
+// ExcludeMatcher decides which files & folders are excluded from a backup. Supported patterns:
+// - Patterns without a slash are globs that are matched against the name of every file & folder, i.e. `*.log` or `node_modules`
+// - Patterns with a slash are matched against the full path, i.e. `/var/www/site/cache` (exact match) or `/var/www/*/cache`
+// An excluded folder is excluded with everything in it.
+type ExcludeMatcher struct {
+	patterns []string
+}
+
+// NewExcludeMatcher creates a matcher for the given patterns, failing if any of the patterns is malformed.
+func NewExcludeMatcher(patterns []string) (*ExcludeMatcher, error) {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, &FileError{"Invalid exclude pattern '" + pattern + "': " + err.Error()}
+		}
 	}
+
+	return &ExcludeMatcher{patterns}, nil
 }
 
-// GetDiskSpaceAvailable uses `df` to resolve the disk where the given folder is on and returns the number of kilobytes available on that disk.
-func GetDiskSpaceAvailable(folder string) (result int64, err error) {
-	// Always require at least 5GB on the disk (should be configurable?)
-	defer func() {
-		result -= 5_000_000
-	}()
+// Matches checks whether the given path should be excluded.
+func (m *ExcludeMatcher) Matches(path string) bool {
+	path = filepath.Clean(path)
+	for _, pattern := range m.patterns {
+		subject := filepath.Base(path)
+		if strings.Contains(pattern, "/") {
+			subject = path
+			pattern = filepath.Clean(pattern)
+		}
+
+		if matched, _ := filepath.Match(pattern, subject); matched {
+			return true
+		}
+	}
+
+	return false
+}
 
-	// Run the command
-	output, err := exec.Command("df", "-k", folder).Output()
+// EstimateTarBallSize estimates the number of bytes the (possibly compressed) tar ball of the given files will take.
+// Compression is assumed to save at least a bit.
+func EstimateTarBallSize(files []string, exclude []string, compression BackupCompression) (int64, error) {
+	excluder, err := NewExcludeMatcher(exclude)
 	if err != nil {
 		return 0, err
 	}
 
-	// Split lines, first one should be header, second the disk
-	lines := strings.Split(string(output), "\n")
-	if len(lines) < 2 {
-		return 0, &FileError{"`df` output doesn't match expected output: " + string(output)}
+	var size int64
+	for _, file := range files {
+		size += GetFolderSize(file, excluder)
 	}
 
-	// Match the file line to fetch the available value
-	// Format: [Filesystem] [Size] [Used] [Available] [...]
-	return matchFirstRegexGroupToInt(
-		regexp.MustCompile("^(?:[^\\s]+\\s+){3}(\\d+)\\s+"),
-		lines[1],
-	)
+	return EstimateCompressedSize(size, compression), nil
 }
 
-// GetFolderSize checks the total size in kilobytes of the given folder using `du`
-// Do note that exclude is not supported on Mac OS X platforms
-func GetFolderSize(folder string, exclude []string) (int64, error) {
-	// Build the arguments for the command
-	arguments := []string{"-s", "-k", folder}
-	if exclude != nil && runtime.GOOS != "darwin" {
-		// Darwin = Mac
-		for _, pattern := range exclude {
-			arguments = append(arguments, "--exclude", pattern)
-		}
+// EstimateCompressedSize applies the (conservative) compression estimate to the given size, if compressed at all.
+func EstimateCompressedSize(size int64, compression BackupCompression) int64 {
+	if compression.Extension() == "" {
+		return size
 	}
 
-	// Run the command
-	output, err := exec.Command("du", arguments...).Output()
+	return int64(float64(size) * compressedSizeEstimate)
+}
+
+// CreateTarBall creates a (possibly compressed & encrypted) tar ball of the given files with the given exclude patterns.
+// The archive is streamed through the compressor, so no uncompressed tar ball is written to disk.
+// Files that couldn't be archived (vanished, permission denied, etc.) don't fail the backup but are returned as warnings.
+func CreateTarBall(targetFilePath string, files []string, exclude []string, compression BackupCompression, encryption BackupEncryption) (warnings []string, err error) {
+	excluder, err := NewExcludeMatcher(exclude)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	// Create the (compressed) tar ball
+	artifact, err := CreateArtifact(targetFilePath, compression, encryption)
+	if err != nil {
+		return nil, err
 	}
 
-	return matchFirstRegexGroupToInt(
-		regexp.MustCompile("^(\\d+)\\s.+"),
-		string(output),
-	)
+	archiver := TarArchiver{
+		writer:    tar.NewWriter(artifact),
+		exclude:   excluder,
+		hardLinks: map[fileId]string{},
+	}
+	for _, file := range files {
+		archiver.add(file)
+	}
+	if archiver.err == nil {
+		archiver.err = archiver.writer.Close()
+	}
+	if archiver.err != nil {
+		artifact.Abort()
+		return archiver.warnings, archiver.err
+	}
+
+	return archiver.warnings, artifact.Close()
 }
 
-// Compress the given file using gzip @ max compression rating
-// Returns the name of the compressed file.
-func Compress(file string) (compressedFile string, err error) {
-	p := exec.Command(
-		"gzip",
-		"-9",
-		file,
-	)
-	if err := p.Run(); err != nil {
-		return "", err
-	}
-	return file + ".gz", nil
+// fileId uniquely identifies a file on the system, used to detect hard links.
+type fileId struct {
+	device uint64
+	inode  uint64
 }
 
-// CreateTarBall creates a (possibly compressed) tar ball of the given files with the given exclude patterns.
-// It will try to check if enough disk space is available if supported by the OS. Do note that this doesn't take any compression into account.
-// (Mac OS X doesn't support --exclude for du commands)
-func CreateTarBall(targetFilePath string, files []string, exclude []string) error {
-	// Fetch the available space we have on the target disk / folder
-	if availableSpace, err := GetDiskSpaceAvailable(filepath.Dir(targetFilePath)); err != nil {
-		return err
-	} else {
-		// Get the total size of required size
-		var requiredSpace int64
-		for _, targetFile := range files {
-			if fileSize, err := GetFolderSize(targetFile, exclude); err == nil {
-				requiredSpace += fileSize
-			} else {
-				return err
+// TarArchiver writes files & folders into a tar archive, keeping ownership, modes, symlinks & hard links intact.
+type TarArchiver struct {
+	writer    *tar.Writer
+	exclude   *ExcludeMatcher
+	hardLinks map[fileId]string // Files with multiple links => Name of the first entry in the archive
+	warnings  []string
+	err       error
+}
+
+func (a *TarArchiver) warn(msg string) {
+	a.warnings = append(a.warnings, msg)
+}
+
+// add walks the given path & adds everything that isn't excluded to the archive.
+func (a *TarArchiver) add(root string) {
+	if a.err != nil {
+		return
+	}
+
+	walkErr := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Vanished or unreadable, skip it (and anything in it)
+			a.warn("Skipped " + path + ": " + err.Error())
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
 			}
+			return nil
 		}
 
-		// Check if we have enough space
-		if requiredSpace > availableSpace {
-			return &FileError{"Not enough disk space available"}
+		if a.exclude.Matches(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if err := a.addEntry(path, info); err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if walkErr != nil {
+		a.err = walkErr
+	}
+}
+
+// addEntry writes a single file, folder or link to the archive.
+// Only returns an error if the archive itself can no longer be written to.
+func (a *TarArchiver) addEntry(path string, info os.FileInfo) error {
+	// Resolve the target of symlinks
+	var link string
+	if info.Mode()&os.ModeSymlink == os.ModeSymlink {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			a.warn("Skipped " + path + ": " + err.Error())
+			return nil
 		}
 	}
 
-	// Build the argument list for the tar command
-	// TODO: Ability to set compression level?
-	var tarArguments []string
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		// Unsupported type of file, i.e. a socket
+		a.warn("Skipped " + path + ": " + err.Error())
+		return nil
+	}
 
-	// => Add all excluded patterns
-	for _, excludePattern := range exclude {
-		tarArguments = append(tarArguments, "--exclude", excludePattern)
+	// Archive paths are relative, same as tar does
+	header.Name = strings.TrimPrefix(filepath.ToSlash(path), "/")
+	if info.IsDir() && !strings.HasSuffix(header.Name, "/") {
+		header.Name += "/"
 	}
 
-	// => Main arguments for building the archive
-	tarArguments = append(tarArguments,
-		"-c",           // Create a new archive
-		"-f",           // With the file name
-		targetFilePath, // This file
-	)
+	if !info.Mode().IsRegular() {
+		return a.writer.WriteHeader(header)
+	}
 
-	// => Add all files that should be added to the archive
-	tarArguments = append(tarArguments, files...)
+	// Files with multiple links are only stored once, other occurrences link to it
+	var hardLink *fileId
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Nlink > 1 {
+		id := fileId{uint64(stat.Dev), uint64(stat.Ino)}
+		if linkName, known := a.hardLinks[id]; known {
+			header.Typeflag = tar.TypeLink
+			header.Linkname = linkName
+			header.Size = 0
+			return a.writer.WriteHeader(header)
+		}
+		hardLink = &id
+	}
+
+	// Open the file before writing the header, it might have vanished or be unreadable
+	file, err := os.Open(path)
+	if err != nil {
+		a.warn("Skipped " + path + ": " + err.Error())
+		return nil
+	}
+	defer file.Close()
 
-	// Create the tar ball
-	if output, err := exec.Command("tar", tarArguments...).CombinedOutput(); err != nil {
-		log.Println(output)
+	if err := a.writer.WriteHeader(header); err != nil {
 		return err
 	}
 
-	return nil
+	// Copy exactly the size in the header, padding the entry if the file shrunk in the meantime
+	written, err := io.CopyN(a.writer, file, header.Size)
+	if err == io.EOF {
+		a.warn("File shrunk while archiving, padded with zeros: " + path)
+		_, err = io.CopyN(a.writer, zeroReader{}, header.Size-written)
+	} else if err != nil && written < header.Size {
+		// Failed while reading the file, the entry still needs to be completed
+		a.warn("Failed to read " + path + " completely, padded with zeros: " + err.Error())
+		_, err = io.CopyN(a.writer, zeroReader{}, header.Size-written)
+	}
+
+	// Only link to the file once it's actually in the archive, a skipped file would leave the links dangling
+	if err == nil && hardLink != nil {
+		a.hardLinks[*hardLink] = header.Name
+	}
+
+	return err
+}
+
+// zeroReader is an endless stream of zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
 }
@@ -0,0 +1,149 @@
+package unitski
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"time"
+)
+
+const partialSuffix = ".partial"
+
+// ArtifactWriter writes a backup file, compressing (& optionally encrypting) everything that is written to it on the fly.
+// The data is written to a partial file which is only moved into place (& recorded in the manifest) once Close succeeds.
+type ArtifactWriter struct {
+	path       string
+	file       *os.File
+	compressor io.WriteCloser
+	encryptor  io.WriteCloser
+	checksum   hash.Hash
+}
+
+// CreateArtifact starts writing an artifact to the given path, compressed & encrypted using the given config.
+func CreateArtifact(path string, compression BackupCompression, encryption BackupEncryption) (*ArtifactWriter, error) {
+	file, err := os.Create(path + partialSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	// Calculate the checksum of the resulting data while writing it
+	checksum := sha256.New()
+	encryptor, err := encryption.NewWriter(io.MultiWriter(file, checksum))
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	compressor, err := compression.NewWriter(encryptor)
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+
+	return &ArtifactWriter{path, file, compressor, encryptor, checksum}, nil
+}
+
+// WrittenSize returns how much of the artifact at the given path is on the disk already, which is the size of its
+// partial file while it's being written. Returns 0 if it doesn't exist (yet).
+func WrittenSize(path string) int64 {
+	for _, file := range []string{path + partialSuffix, path} {
+		if stat, err := os.Stat(file); err == nil {
+			return stat.Size()
+		}
+	}
+
+	return 0
+}
+
+func (w *ArtifactWriter) Write(p []byte) (int, error) {
+	return w.compressor.Write(p)
+}
+
+// Close flushes the compressor, moves the finished file into place & records its checksum in the manifest.
+// The partial file is removed if anything fails before it's moved into place.
+func (w *ArtifactWriter) Close() (err error) {
+	defer func() {
+		if err != nil {
+			_ = os.Remove(w.file.Name())
+		}
+	}()
+
+	if err = w.compressor.Close(); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	if err = w.encryptor.Close(); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	info, err := w.file.Stat()
+	if err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	if err = w.file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(w.file.Name(), w.path); err != nil {
+		return err
+	}
+
+	entry := ManifestEntry{hex.EncodeToString(w.checksum.Sum(nil)), info.Size(), time.Now()}
+	if err := recordInManifest(w.path, entry); err != nil {
+		return &FileError{"Failed to record " + w.path + " in the manifest: " + err.Error()}
+	}
+
+	return nil
+}
+
+// Abort stops writing & removes the partial file.
+func (w *ArtifactWriter) Abort() {
+	_ = w.compressor.Close()
+	_ = w.encryptor.Close()
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
+
+// ArtifactReader reads a backup file, decrypting & decompressing it on the fly based on its extension.
+type ArtifactReader struct {
+	file         *os.File
+	decompressor io.ReadCloser
+}
+
+// OpenArtifact opens the backup file for reading its decompressed content.
+// The identity is only required if the backup is encrypted.
+func OpenArtifact(path string, identity *Identity) (*ArtifactReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decryptor, err := identity.NewReader(path, file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	decompressor, err := CompressionOfFile(path).NewReader(decryptor)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &ArtifactReader{file, decompressor}, nil
+}
+
+func (r *ArtifactReader) Read(p []byte) (int, error) {
+	return r.decompressor.Read(p)
+}
+
+func (r *ArtifactReader) Close() error {
+	err := r.decompressor.Close()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
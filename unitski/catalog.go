@@ -0,0 +1,122 @@
+package unitski
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// backupTiers are all tier folders of a project, from the slowest to the fastest change rate.
+// A new backup is stored in the slowest tier it's added to, the faster tiers symlink to it.
+var backupTiers = []string{yearlyDir, monthlyDir, weeklyDir, dailyDir, hourlyDir}
+
+// isBackupTier checks whether the folder (with trailing slash) is one of the tiers.
+func isBackupTier(folder string) bool {
+	for _, tier := range backupTiers {
+		if tier == folder {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Backup is a single backup of a project, which might be present in multiple tiers.
+type Backup struct {
+	Filename string
+	Date     time.Time
+	Tiers    []string // The tier folders that contain (a symlink to) the backup
+	Path     string   // Absolute path to the physical file, with all symlinks resolved
+	Size     int64    // Size of the physical file
+}
+
+// FindBackups lists all backups in the tiers of the given project folder, sorted from oldest to newest.
+func FindBackups(projectFolder string) ([]Backup, error) {
+	regex := regexp.MustCompile(fileDatePattern)
+	backups := map[string]*Backup{}
+
+	for _, tier := range backupTiers {
+		entries, err := os.ReadDir(projectFolder + tier)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || !regex.MatchString(entry.Name()) {
+				continue
+			}
+
+			backup, known := backups[entry.Name()]
+			if !known {
+				date, ok := backupTime(entry.Name())
+				if !ok {
+					return nil, &FileError{"Backup has an invalid date: " + projectFolder + tier + entry.Name()}
+				}
+				backup = &Backup{Filename: entry.Name(), Date: date}
+				backups[entry.Name()] = backup
+			}
+			backup.Tiers = append(backup.Tiers, tier)
+
+			// Resolve the physical file, the tier with the actual file wins
+			if backup.Path == "" || entry.Type()&os.ModeSymlink == 0 {
+				if path, err := filepath.EvalSymlinks(projectFolder + tier + entry.Name()); err == nil {
+					backup.Path, _ = filepath.Abs(path)
+					if info, err := os.Stat(path); err == nil {
+						backup.Size = info.Size()
+					}
+				}
+			}
+		}
+	}
+
+	var result []Backup
+	for _, backup := range backups {
+		result = append(result, *backup)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Date.Equal(result[j].Date) {
+			return result[i].Filename < result[j].Filename
+		}
+		return result[i].Date.Before(result[j].Date)
+	})
+
+	return result, nil
+}
+
+// Timestamp returns the date (& time, for targets with hourly backups) of the backup as used in its filename.
+func (b Backup) Timestamp() string {
+	if match := regexp.MustCompile(fileDatePattern).FindStringSubmatch(b.Filename); match != nil && match[2] != "" {
+		return b.Date.Format(fileTimestampFormat)
+	}
+
+	return b.Date.Format(fileDateFormat)
+}
+
+// FindBackup finds the backup of the given date (YYYY-MM-DD) or timestamp (YYYY-MM-DD_HH-MM) in the project folder.
+// The latest backup (of the date) is returned if no date is given (or multiple backups were made on the date).
+func FindBackup(projectFolder string, date string) (Backup, error) {
+	backups, err := FindBackups(projectFolder)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	for i := len(backups) - 1; i >= 0; i-- {
+		backup := backups[i]
+		if backup.Path == "" {
+			// Dangling symlink, nothing to restore
+			continue
+		}
+		if date == "" || backup.Date.Format(fileDateFormat) == date || backup.Timestamp() == date {
+			return backup, nil
+		}
+	}
+
+	if date == "" {
+		return Backup{}, &FileError{"No backups found in " + projectFolder}
+	}
+	return Backup{}, &FileError{"No backup of " + date + " found in " + projectFolder}
+}
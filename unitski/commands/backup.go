@@ -2,43 +2,61 @@ package commands
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/docker/docker/client"
 	"github.com/getsentry/sentry-go"
 	"log"
-	"path/filepath"
 	"time"
 	"unitski-backup/unitski"
 )
 
 // Sync will trigger a full sync of all databases & files in the given config file.
-func Sync(configFilePath string) {
+// Returns an error if the backup folder is in use, failed backups are only logged & reported to Sentry.
+func Sync(configFilePath string) error {
 	fmt.Println("Running...")
 	unitski.SetLogger()
 	log.Println("---- Starting backup routine")
 
 	// Load config
-	config := unitski.LoadConfig(configFilePath)
+	config, err := unitski.LoadConfig(configFilePath)
+	if err != nil {
+		panic(err)
+	}
+
+	minFreeSpace, err := unitski.ParseMinFreeSpace(config.MinFreeSpace)
+	if err != nil {
+		panic(err)
+	}
+
+	// Never rotate the backups while a prune (or another backup run) does
+	unlock, err := unitski.LockBackupFolder(config.Folder)
+	if err != nil {
+		log.Println("[error] " + err.Error())
+		sentry.CaptureException(err)
+		return err
+	}
+	defer unlock()
 
 	// Init docker
 	cli, ctx := unitski.InitDocker()
 
-	// Backup DBs
-	databases(cli, ctx, config)
-	// Backup files
-	files(config)
+	// Backup DBs & files, running independent targets in parallel
+	now := time.Now()
+	var jobs []backupJob
+	jobs = append(jobs, databases(cli, ctx, config, minFreeSpace, now)...)
+	jobs = append(jobs, files(config, minFreeSpace, now)...)
+	runJobs(jobs, config.GetConcurrency())
 
-	// TODO: Async
-	// Check if required commands are available
-	// Start 2 threads: 1 for processing backups, 1 for syncing to backup
+	// Make sure all backups together stay within the quota
+	enforceQuota(config, log.Default())
 
 	log.Println("---- All done!")
 	fmt.Println("Done.")
+	return nil
 }
 
-func databases(cli *client.Client, ctx context.Context, config unitski.BackupConfig) {
-	date := time.Now().Format("2006-01-02")
-
+func databases(cli *client.Client, ctx context.Context, config unitski.BackupConfig, minFreeSpace unitski.MinFreeSpace, now time.Time) (jobs []backupJob) {
 	// Loop through each database
 	for _, database := range config.Databases {
 		if !database.Enabled {
@@ -46,106 +64,255 @@ func databases(cli *client.Client, ctx context.Context, config unitski.BackupCon
 			continue
 		}
 
-		log.Println("[info] Starting backup of database: " + database.Name)
+		database := database
+		jobs = append(jobs, backupJob{
+			name:      database.Name,
+			container: database.Container,
+			run: func(logger *log.Logger, space *spaceReservation) {
+				backupDatabase(cli, ctx, config, database, minFreeSpace, now, space, logger)
+			},
+		})
+	}
 
-		// Determine the dump file
-		projectFolder := config.Folder + database.Name + "/"
-		dumpToFile := projectFolder + database.Name + "_" + date + ".sql"
+	return jobs
+}
 
-		// Create the project folder if not done yet & check if we should run a backup
-		shouldBackup, err := unitski.CheckProjectFolder(projectFolder, filepath.Base(dumpToFile+".gz"), database.Interval)
-		if err != nil {
-			log.Println("[error] ", err.Error())
-			sentry.CaptureException(err)
-			continue
-		} else if !shouldBackup.Any() {
-			log.Println("[info] No backup required today for: " + database.Name)
-			continue
-		}
+func backupDatabase(
+	cli *client.Client,
+	ctx context.Context,
+	config unitski.BackupConfig,
+	database unitski.BackupConfigDatabase,
+	minFreeSpace unitski.MinFreeSpace,
+	now time.Time,
+	space *spaceReservation,
+	logger *log.Logger,
+) {
+	logger.Println("[info] Starting backup of database: " + database.Name)
 
-		// Execute the dump
-		log.Println("[info] Starting dump to file: " + dumpToFile)
-		err = unitski.DumpMySqlDatabase(cli, ctx, database, dumpToFile)
-		if err != nil {
-			log.Println("[error] Failed to dump MySQL database of " + database.Name + ": " + err.Error())
-			sentry.CaptureException(err)
-			continue
-		}
+	// Determine the dump file
+	projectFolder := config.Folder + database.Name + "/"
+	date := unitski.BackupTimestamp(now, database.Interval)
+	dumpToFile := projectFolder + database.Name + "_" + date + database.Type.DumpExtension() + database.GetCompression().Extension() + database.Encryption.Extension()
 
-		// Compress the dump
-		log.Println("[info] Compressing file: " + dumpToFile)
-		compressedFile, err := unitski.Compress(dumpToFile)
-		if err != nil {
-			log.Print("[error] Failed to compress file: " + dumpToFile + " | Err: " + err.Error())
-			sentry.CaptureException(err)
-			continue
-		}
+	// Create the project folder if not done yet & check if we should run a backup
+	shouldBackup, err := unitski.CheckProjectFolder(projectFolder, now, database.Interval, logger)
+	if err != nil {
+		logger.Println("[error] ", err.Error())
+		sentry.CaptureException(err)
+		return
+	} else if !shouldBackup.Any() {
+		logger.Println("[info] No backup required today for: " + database.Name)
+		return
+	}
+
+	// Check if the dump will (probably) fit on the disk
+	size, supported, err := unitski.EstimateDatabaseSize(cli, ctx, database, logger)
+	if err != nil {
+		logger.Println("[error] Failed to determine the size of database " + database.Name + ": " + err.Error())
+		sentry.CaptureException(err)
+		return
+	} else if !supported {
+		logger.Println("[info] Unable to determine the size of a " + string(database.Type) + " database, only checking the min free space")
+	}
+	requiredSpace := unitski.EstimateCompressedSize(size, database.GetCompression())
+	if !checkSpace(database.Name, projectFolder, dumpToFile, requiredSpace, minFreeSpace, database.OnLowSpace, space, logger) {
+		return
+	}
 
-		// Rotate the file through
-		log.Println("Rotating result file into backups")
-		err = unitski.RotateFile(compressedFile, shouldBackup, database.Interval)
+	// Execute the dump, which is compressed on the fly (if enabled)
+	logger.Println("[info] Starting dump to file: " + dumpToFile)
+	err = unitski.DumpDatabase(cli, ctx, database, dumpToFile, logger)
+	space.release()
+	if err != nil {
+		logger.Println("[error] Failed to dump database of " + database.Name + ": " + err.Error())
+		sentry.CaptureException(err)
+		return
+	}
+
+	// Rotate the file through
+	logger.Println("Rotating result file into backups")
+	err = unitski.RotateFile(dumpToFile, shouldBackup, database.Interval, database.Retention, logger)
+	if err != nil {
+		logger.Print("[error] Error while rotating file: " + err.Error())
+		sentry.CaptureException(err)
+		return
+	}
+
+	// Mirror the project folder to the sync folder
+	if config.SyncFolder != "" {
+		logger.Println("[info] Syncing project folder to: " + config.SyncFolder)
+		err = unitski.SyncProjectFolder(projectFolder, config.SyncFolder, database.RotateSyncedMonthlyBackups, logger)
 		if err != nil {
-			log.Print("[error] Error while rotating file: " + err.Error())
+			logger.Print("[error] Error while syncing project folder: " + err.Error())
 			sentry.CaptureException(err)
-			continue
+			return
 		}
-
-		// TODO: Queue sync
-
-		// All done?
 	}
-}
 
-func files(config unitski.BackupConfig) {
-	date := time.Now().Format("2006-01-02")
+	// All done?
+}
 
-	// Loop through each database
+func files(config unitski.BackupConfig, minFreeSpace unitski.MinFreeSpace, now time.Time) (jobs []backupJob) {
+	// Loop through each files backup
 	for _, fileBackup := range config.Files {
 		if !fileBackup.Enabled {
 			log.Println("[info] Skipping files backup: " + fileBackup.Name + " (is disabled)")
 			continue
 		}
 
-		log.Println("[info] Starting backup of files: " + fileBackup.Name)
+		fileBackup := fileBackup
+		jobs = append(jobs, backupJob{
+			name: fileBackup.Name,
+			run: func(logger *log.Logger, space *spaceReservation) {
+				backupFiles(config, fileBackup, minFreeSpace, now, space, logger)
+			},
+		})
+	}
 
-		// Determine the target tar file
-		projectFolder := config.Folder + fileBackup.Name + "/"
-		tarBallFile := projectFolder + fileBackup.Name + "_" + date + ".tar"
-		if fileBackup.Compress {
-			tarBallFile = tarBallFile + ".gz"
-		}
+	return jobs
+}
+
+func backupFiles(
+	config unitski.BackupConfig,
+	fileBackup unitski.BackupConfigFiles,
+	minFreeSpace unitski.MinFreeSpace,
+	now time.Time,
+	space *spaceReservation,
+	logger *log.Logger,
+) {
+	logger.Println("[info] Starting backup of files: " + fileBackup.Name)
 
-		// Create the project folder if not done yet & check if we should run a backup
-		shouldBackup, err := unitski.CheckProjectFolder(projectFolder, filepath.Base(tarBallFile), fileBackup.Interval)
+	// Determine the target tar file
+	projectFolder := config.Folder + fileBackup.Name + "/"
+	date := unitski.BackupTimestamp(now, fileBackup.Interval)
+	tarBallFile := projectFolder + fileBackup.Name + "_" + date + ".tar" + fileBackup.GetCompression().Extension() + fileBackup.Encryption.Extension()
+
+	// Create the project folder if not done yet & check if we should run a backup
+	shouldBackup, err := unitski.CheckProjectFolder(projectFolder, now, fileBackup.Interval, logger)
+	if err != nil {
+		logger.Println("[error] ", err.Error())
+		sentry.CaptureException(err)
+		return
+	} else if !shouldBackup.Any() {
+		logger.Println("[info] No backup required today for: " + fileBackup.Name)
+		return
+	}
+
+	// Check if the tar ball will (probably) fit on the disk
+	requiredSpace, err := unitski.EstimateTarBallSize(fileBackup.Files, fileBackup.Exclude, fileBackup.GetCompression())
+	if err != nil {
+		logger.Println("[error] Failed to determine the size of the tar ball: " + err.Error())
+		sentry.CaptureException(err)
+		return
+	}
+	if !checkSpace(fileBackup.Name, projectFolder, tarBallFile, requiredSpace, minFreeSpace, fileBackup.OnLowSpace, space, logger) {
+		return
+	}
+
+	// Create the tar ball
+	logger.Println("[info] Creating tar ball: " + tarBallFile)
+	warnings, err := unitski.CreateTarBall(tarBallFile, fileBackup.Files, fileBackup.Exclude, fileBackup.GetCompression(), fileBackup.Encryption)
+	space.release()
+	for _, warning := range warnings {
+		logger.Println("[warning] " + warning)
+	}
+	if err != nil {
+		logger.Print("[error] Error while creating tar ball: " + err.Error())
+		sentry.CaptureException(err)
+		return
+	}
+
+	// Rotate the file through
+	logger.Println("[info] Rotating result file into backups")
+	err = unitski.RotateFile(tarBallFile, shouldBackup, fileBackup.Interval, fileBackup.Retention, logger)
+	if err != nil {
+		logger.Print("[error] Error while rotating file: " + err.Error())
+		sentry.CaptureException(err)
+		return
+	}
+
+	// Mirror the project folder to the sync folder
+	if config.SyncFolder != "" {
+		logger.Println("[info] Syncing project folder to: " + config.SyncFolder)
+		err = unitski.SyncProjectFolder(projectFolder, config.SyncFolder, fileBackup.RotateSyncedMonthlyBackups, logger)
 		if err != nil {
-			log.Println("[error] ", err.Error())
+			logger.Print("[error] Error while syncing project folder: " + err.Error())
 			sentry.CaptureException(err)
-			continue
-		} else if !shouldBackup.Any() {
-			log.Println("[info] No backup required today for: " + fileBackup.Name)
-			continue
+			return
 		}
+	}
 
-		// Create the tar ball
-		log.Println("[info] Creating tar ball: " + tarBallFile)
-		err = unitski.CreateTarBall(tarBallFile, fileBackup.Files, fileBackup.Exclude)
-		if err != nil {
-			log.Print("[error] Error while creating tar ball: " + err.Error())
-			sentry.CaptureException(err)
-			continue
+	// All done?
+}
+
+// checkSpace checks whether the backup file fits on the disk, applying the low space policy of the target if it doesn't.
+// The space is reserved for the file (until it's written) if it continues. Returns whether the backup should continue.
+func checkSpace(name string, folder string, file string, requiredSpace int64, minFreeSpace unitski.MinFreeSpace, policy unitski.LowSpacePolicy, space *spaceReservation, logger *log.Logger) bool {
+	var err error
+	var spaceErr *unitski.NotEnoughSpaceError
+	space.claim(file, requiredSpace, func(reserved int64) bool {
+		err = unitski.CheckDiskSpace(folder, requiredSpace, reserved, minFreeSpace)
+		return err == nil || (errors.As(err, &spaceErr) && policy == unitski.LowSpaceWarn)
+	})
+	if err == nil {
+		return true
+	}
+
+	if errors.As(err, &spaceErr) {
+		switch policy {
+		case unitski.LowSpaceSkip:
+			logger.Println("[info] Skipping backup of " + name + ": " + err.Error())
+			return false
+		case unitski.LowSpaceWarn:
+			logger.Println("[warning] Still trying backup of " + name + ": " + err.Error())
+			return true
 		}
+	}
 
-		// Rotate the file through
-		log.Println("[info] Rotating result file into backups")
-		err = unitski.RotateFile(tarBallFile, shouldBackup, fileBackup.Interval)
-		if err != nil {
-			log.Print("[error] Error while rotating file: " + err.Error())
+	logger.Println("[error] Unable to backup " + name + ": " + err.Error())
+	sentry.CaptureException(err)
+	return false
+}
+
+// enforceQuota removes the oldest backups of all targets until the backup folder fits within the quota (if any).
+func enforceQuota(config unitski.BackupConfig, logger *log.Logger) {
+	quota, err := config.GetQuota()
+	if err != nil || quota <= 0 {
+		return
+	}
+
+	var projects []string
+	rotateMonthly := map[string]bool{}
+	for _, database := range config.Databases {
+		projects = append(projects, database.Name)
+		rotateMonthly[database.Name] = database.RotateSyncedMonthlyBackups
+	}
+	for _, fileBackup := range config.Files {
+		projects = append(projects, fileBackup.Name)
+		rotateMonthly[fileBackup.Name] = fileBackup.RotateSyncedMonthlyBackups
+	}
+
+	changed, err := unitski.EnforceQuota(config.Folder, projects, quota, logger)
+	if err != nil {
+		logger.Println("[error] Failed to enforce the quota: " + err.Error())
+		sentry.CaptureException(err)
+	}
+
+	// Remove the deleted backups from the mirror as well
+	for _, project := range changed {
+		if err := syncProject(config, project, rotateMonthly[project], logger); err != nil {
+			logger.Print("[error] Error while syncing project folder of " + project + ": " + err.Error())
 			sentry.CaptureException(err)
-			continue
 		}
+	}
+}
 
-		// TODO: Queue sync
-
-		// All done?
+// syncProject mirrors the project folder of the target to the sync folder (if any).
+func syncProject(config unitski.BackupConfig, name string, rotateMonthly bool, logger *log.Logger) error {
+	if config.SyncFolder == "" {
+		return nil
 	}
+
+	logger.Println("[info] Syncing project folder to: " + config.SyncFolder)
+	return unitski.SyncProjectFolder(config.Folder+name+"/", config.SyncFolder, rotateMonthly, logger)
 }
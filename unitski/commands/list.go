@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"unitski-backup/unitski"
+)
+
+type listedTarget struct {
+	Name    string         `json:"name"`
+	Type    string         `json:"type"`
+	Folder  string         `json:"folder"`
+	Backups []listedBackup `json:"backups"`
+}
+
+type listedBackup struct {
+	Filename string   `json:"filename"`
+	Date     string   `json:"date"`
+	Tiers    []string `json:"tiers"`
+	Size     int64    `json:"size"`
+	Path     string   `json:"path"` // Empty if all entries are dangling symlinks
+}
+
+// List prints all backups of every target in the config (or only the given target), as a table or JSON.
+func List(configFilePath string, target string, asJson bool) error {
+	// Load config
+	config, err := unitski.LoadConfig(configFilePath)
+	if err != nil {
+		return err
+	}
+
+	// Collect the backups of all targets
+	var targets []listedTarget
+	for _, database := range config.Databases {
+		if target == "" || database.Name == target {
+			targets = append(targets, listedTarget{Name: database.Name, Type: "database"})
+		}
+	}
+	for _, fileBackup := range config.Files {
+		if target == "" || fileBackup.Name == target {
+			targets = append(targets, listedTarget{Name: fileBackup.Name, Type: "files"})
+		}
+	}
+	if target != "" && len(targets) == 0 {
+		return errors.New("No database or files backup found with name: " + target)
+	}
+
+	for i := range targets {
+		targets[i].Folder = config.Folder + targets[i].Name + "/"
+		backups, err := unitski.FindBackups(targets[i].Folder)
+		if err != nil {
+			return err
+		}
+
+		targets[i].Backups = []listedBackup{}
+		for _, backup := range backups {
+			targets[i].Backups = append(targets[i].Backups, listedBackup{
+				Filename: backup.Filename,
+				Date:     backup.Timestamp(),
+				Tiers:    tierNames(backup.Tiers),
+				Size:     backup.Size,
+				Path:     backup.Path,
+			})
+		}
+	}
+
+	// Print them
+	if asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(targets)
+	}
+
+	for i, listed := range targets {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(listed.Name + " (" + listed.Type + "): " + listed.Folder)
+		if len(listed.Backups) == 0 {
+			fmt.Println("  No backups")
+			continue
+		}
+
+		table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(table, "  DATE\tTIERS\tSIZE\tFILE")
+		for _, backup := range listed.Backups {
+			size, path := unitski.FormatSize(backup.Size), backup.Path
+			if path == "" {
+				size, path = "-", "(missing, dangling symlink)"
+			}
+			_, _ = fmt.Fprintln(table, "  "+backup.Date+"\t"+strings.Join(backup.Tiers, ",")+"\t"+size+"\t"+path)
+		}
+		if err := table.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tierNames converts tier folders ("daily/") to their names ("daily").
+func tierNames(tiers []string) []string {
+	names := []string{}
+	for _, tier := range tiers {
+		names = append(names, strings.TrimSuffix(tier, "/"))
+	}
+
+	return names
+}
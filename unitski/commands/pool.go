@@ -0,0 +1,119 @@
+package commands
+
+import (
+	"log"
+	"sync"
+	"unitski-backup/unitski"
+)
+
+// backupJob is the backup of a single target.
+type backupJob struct {
+	name      string
+	container string // The docker container the job uses, if any. Only one job per container runs at a time.
+	run       func(logger *log.Logger, space *spaceReservation)
+}
+
+// spaceReservation is the disk space claimed by a running job, so that parallel jobs don't count on the same free space.
+type spaceReservation struct {
+	queue *jobQueue
+	file  string // The backup file the job writes
+	bytes int64  // The estimated size of the backup file
+}
+
+// claim reserves the bytes for the file if the check (given the space reserved by all running jobs) passes.
+// Returns the result of the check, the reservation is released once the file is written or the job is done.
+func (r *spaceReservation) claim(file string, bytes int64, check func(reserved int64) bool) bool {
+	r.queue.mutex.Lock()
+	defer r.queue.mutex.Unlock()
+
+	// The part of a backup that is written already is taken from the free space, so only the rest is reserved
+	var reserved int64
+	for running := range r.queue.reservations {
+		reserved += max(0, running.bytes-unitski.WrittenSize(running.file))
+	}
+	if !check(reserved) {
+		return false
+	}
+
+	r.file = file
+	r.bytes = bytes
+	r.queue.reservations[r] = true
+	return true
+}
+
+// release frees the reserved space, i.e. once the backup file is written & the free space reflects it.
+func (r *spaceReservation) release() {
+	r.queue.mutex.Lock()
+	delete(r.queue.reservations, r)
+	r.queue.mutex.Unlock()
+}
+
+// jobQueue hands out the jobs in order, skipping over jobs whose container is busy with another job.
+type jobQueue struct {
+	mutex          sync.Mutex
+	changed        *sync.Cond
+	pending        []backupJob
+	busyContainers map[string]bool
+	reservations   map[*spaceReservation]bool // Disk space claimed by the running jobs
+}
+
+// next blocks until a job can be started, returns false once all jobs have been handed out.
+func (q *jobQueue) next() (backupJob, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for len(q.pending) > 0 {
+		for index, job := range q.pending {
+			if job.container != "" && q.busyContainers[job.container] {
+				continue
+			}
+
+			q.pending = append(q.pending[:index:index], q.pending[index+1:]...)
+			if job.container != "" {
+				q.busyContainers[job.container] = true
+			}
+			return job, true
+		}
+
+		// All remaining jobs are waiting on a container
+		q.changed.Wait()
+	}
+
+	return backupJob{}, false
+}
+
+// done marks the container of the job as free again & releases the disk space it reserved.
+func (q *jobQueue) done(job backupJob, space *spaceReservation) {
+	q.mutex.Lock()
+	delete(q.busyContainers, job.container)
+	delete(q.reservations, space)
+	q.mutex.Unlock()
+
+	q.changed.Broadcast()
+}
+
+// runJobs runs all jobs in a pool of the given number of workers & waits for them to complete.
+// Every job logs with its name as prefix, so interleaved output of parallel jobs stays readable.
+func runJobs(jobs []backupJob, concurrency int) {
+	queue := &jobQueue{
+		pending:        jobs,
+		busyContainers: map[string]bool{},
+		reservations:   map[*spaceReservation]bool{},
+	}
+	queue.changed = sync.NewCond(&queue.mutex)
+
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job, ok := queue.next(); ok; job, ok = queue.next() {
+				space := &spaceReservation{queue: queue}
+				job.run(log.New(log.Writer(), "["+job.name+"] ", log.Flags()|log.Lmsgprefix), space)
+				queue.done(job, space)
+			}
+		}()
+	}
+
+	workers.Wait()
+}
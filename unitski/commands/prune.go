@@ -0,0 +1,144 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"unitski-backup/unitski"
+)
+
+// pruneTarget is a target with its retention policy.
+type pruneTarget struct {
+	name          string
+	interval      unitski.BackupInterval
+	retention     unitski.BackupRetention
+	rotateMonthly bool // Whether the monthly backups are rotated out of the sync folder
+}
+
+// Prune applies the retention policy (interval, retention & quota) to the backups of every target in the config
+// (or only the given target) without making a new backup. With dry run it only prints what would be changed.
+func Prune(configFilePath string, target string, dryRun bool) error {
+	// Load config
+	config, err := unitski.LoadConfig(configFilePath)
+	if err != nil {
+		return err
+	}
+
+	// Collect the targets with their retention policy
+	var targets []pruneTarget
+	for _, database := range config.Databases {
+		if target == "" || database.Name == target {
+			targets = append(targets, pruneTarget{database.Name, database.Interval, database.Retention, database.RotateSyncedMonthlyBackups})
+		}
+	}
+	for _, fileBackup := range config.Files {
+		if target == "" || fileBackup.Name == target {
+			targets = append(targets, pruneTarget{fileBackup.Name, fileBackup.Interval, fileBackup.Retention, fileBackup.RotateSyncedMonthlyBackups})
+		}
+	}
+	if target != "" && len(targets) == 0 {
+		return errors.New("No database or files backup found with name: " + target)
+	}
+
+	if dryRun {
+		fmt.Println("Dry run, nothing will be changed")
+	} else {
+		// Never prune while a backup is rotating the same folders
+		unlock, err := unitski.LockBackupFolder(config.Folder)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		// The moved & removed files are logged, like the rotation after a backup
+		unitski.SetLogger()
+		log.Println("---- Starting prune routine")
+	}
+
+	// Plan & apply the rotation of each project folder
+	now := time.Now()
+	report := configReport{}
+	states := map[string]unitski.ProjectState{}
+	for _, prune := range targets {
+		projectFolder := config.Folder + prune.name + "/"
+		report.section(prune.name)
+
+		state, err := unitski.ReadProjectState(projectFolder)
+		if err != nil {
+			report.problem("Unable to read " + projectFolder + ": " + err.Error())
+			continue
+		}
+		actions, err := unitski.PlanRotation(state, "", 0, unitski.ShouldBackup{}, prune.interval, prune.retention, now)
+		if err != nil {
+			report.problem("Unable to plan the rotation: " + err.Error())
+			continue
+		}
+
+		// The state now holds what's left after the rotation, which the quota is based on
+		if pruneProject(&report, "", config, prune, actions, dryRun) {
+			states[projectFolder] = state
+		}
+	}
+
+	// The quota spans all targets, so it's only enforced when pruning all of them
+	quota, err := config.GetQuota()
+	if err != nil {
+		return err
+	}
+	if quota > 0 && target == "" {
+		report.section("quota (" + unitski.FormatSize(quota) + ")")
+		if len(states) < len(targets) {
+			// Without the size of every target the quota can't be checked
+			report.problem("Skipped, not every target could be pruned")
+		} else if plans := unitski.PlanQuota(states, quota); len(plans) == 0 {
+			report.ok("The backup folder fits in the quota")
+		} else {
+			for _, prune := range targets {
+				projectFolder := config.Folder + prune.name + "/"
+				if actions, exists := plans[projectFolder]; exists {
+					pruneProject(&report, prune.name+": ", config, prune, actions, dryRun)
+				}
+			}
+		}
+	}
+
+	if report.problems > 0 {
+		return fmt.Errorf("found %d problem(s) while pruning", report.problems)
+	}
+
+	return nil
+}
+
+// pruneProject prints the planned actions for the project folder of the target & applies them (unless it's a dry run).
+// The changes are mirrored to the sync folder (if any), so that the deleted backups disappear from there as well.
+// The prefix is put in front of each printed line, i.e. to tell the targets apart in the quota section.
+// Returns whether all actions were applied (or would be, with a dry run).
+func pruneProject(report *configReport, prefix string, config unitski.BackupConfig, prune pruneTarget, actions []unitski.RotationAction, dryRun bool) bool {
+	if len(actions) == 0 {
+		report.ok(prefix + "Nothing to prune")
+		return true
+	}
+
+	if dryRun {
+		for _, action := range actions {
+			report.info(prefix + "Would " + action.String())
+		}
+		if config.SyncFolder != "" {
+			report.info(prefix + "Would sync the changes to " + config.SyncFolder)
+		}
+		return true
+	}
+
+	projectFolder := config.Folder + prune.name + "/"
+	if err := unitski.ApplyRotation(projectFolder, actions, log.Default()); err != nil {
+		report.problem(prefix + "Failed to prune " + projectFolder + ": " + err.Error())
+		return false
+	}
+	report.ok(fmt.Sprintf("%sApplied %d change(s)", prefix, len(actions)))
+
+	if err := syncProject(config, prune.name, prune.rotateMonthly, log.Default()); err != nil {
+		report.problem(prefix + "Failed to sync " + projectFolder + ": " + err.Error())
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unitski-backup/unitski"
+)
+
+// RestoreOptions are the options of the restore command.
+type RestoreOptions struct {
+	Target    string   // Name of the database or files backup to restore
+	Date      string   // Date (YYYY-MM-DD) of the backup, the latest backup is used if empty
+	Database  string   // Restore into this database instead of the configured one
+	Container string   // Restore into this container instead of the configured one
+	To        string   // Folder to extract a files backup into
+	Paths     []string // Only extract these paths or glob patterns from a files backup
+	DryRun    bool     // Only list what would be extracted from a files backup
+	Identity  string   // Path to the private key that decrypts encrypted backups
+	Yes       bool     // Skip the confirmation prompt
+}
+
+// Restore restores a backup of the given target from the backup folder.
+func Restore(configFilePath string, options RestoreOptions) error {
+	// Load config
+	config, err := unitski.LoadConfig(configFilePath)
+	if err != nil {
+		return err
+	}
+
+	for _, database := range config.Databases {
+		if database.Name == options.Target {
+			if options.To != "" || len(options.Paths) > 0 || options.DryRun {
+				return errors.New("--to, --path & --dry-run are only supported for files backups")
+			}
+			return restoreDatabase(config, database, options)
+		}
+	}
+	for _, fileBackup := range config.Files {
+		if fileBackup.Name == options.Target {
+			if options.Database != "" || options.Container != "" {
+				return errors.New("--database & --container are only supported for database backups")
+			}
+			return restoreFiles(config, fileBackup, options)
+		}
+	}
+
+	return errors.New("No database or files backup found with name: " + options.Target)
+}
+
+func restoreDatabase(config unitski.BackupConfig, database unitski.BackupConfigDatabase, options RestoreOptions) error {
+	// Find the backup
+	backup, err := unitski.FindBackup(config.Folder+database.Name+"/", options.Date)
+	if err != nil {
+		return err
+	}
+
+	if options.Container != "" {
+		database.Container = options.Container
+	}
+	into := database.Container
+	if options.Database != "" {
+		into += " (database: " + options.Database + ")"
+	}
+
+	fmt.Println("Backup:     " + backup.Path)
+	fmt.Println("Restore to: " + into)
+	if !options.Yes && !confirm("This will overwrite the existing data, continue?") {
+		return errors.New("Restore cancelled")
+	}
+
+	identity, err := loadIdentity(options.Identity)
+	if err != nil {
+		return err
+	}
+
+	// Restore it
+	cli, ctx := unitski.InitDocker()
+	logger := log.Default()
+	logger.Println("[info] Restoring " + backup.Filename + " into " + into)
+	if err := unitski.RestoreDatabase(cli, ctx, database, backup.Path, options.Database, identity, logger); err != nil {
+		return err
+	}
+
+	logger.Println("[info] Restored " + backup.Filename)
+	return nil
+}
+
+func restoreFiles(config unitski.BackupConfig, fileBackup unitski.BackupConfigFiles, options RestoreOptions) error {
+	// Find the backup
+	backup, err := unitski.FindBackup(config.Folder+fileBackup.Name+"/", options.Date)
+	if err != nil {
+		return err
+	}
+
+	// Never extract over the original files unless explicitly asked to
+	to := options.To
+	if to == "" {
+		to = fileBackup.Name + "_" + backup.Timestamp()
+		if _, err := os.Lstat(to); err == nil && !options.DryRun {
+			return errors.New("Folder " + to + " already exists, use --to to restore into an existing folder")
+		}
+	}
+	if to, err = filepath.Abs(to); err != nil {
+		return err
+	}
+
+	identity, err := loadIdentity(options.Identity)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Backup:     " + backup.Path)
+	fmt.Println("Restore to: " + to)
+	if len(options.Paths) > 0 {
+		fmt.Println("Paths:      " + strings.Join(options.Paths, ", "))
+	}
+
+	if options.DryRun {
+		restored, warnings, err := unitski.ExtractTarBall(backup.Path, to, options.Paths, true, identity)
+		for _, path := range restored {
+			fmt.Println("  " + path)
+		}
+		printWarnings(warnings)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Would restore %d entries\n", len(restored))
+		return nil
+	}
+
+	if entries, err := os.ReadDir(to); err == nil && len(entries) > 0 && !options.Yes {
+		if !confirm("The folder isn't empty, existing files will be overwritten. Continue?") {
+			return errors.New("Restore cancelled")
+		}
+	}
+
+	// Extract it
+	logger := log.Default()
+	logger.Println("[info] Restoring " + backup.Filename + " into " + to)
+	restored, warnings, err := unitski.ExtractTarBall(backup.Path, to, options.Paths, false, identity)
+	printWarnings(warnings)
+	if err != nil {
+		return err
+	}
+
+	logger.Printf("[info] Restored %d entries from %s\n", len(restored), backup.Filename)
+	return nil
+}
+
+// loadIdentity loads the private key of the given file, if any.
+func loadIdentity(identityFile string) (*unitski.Identity, error) {
+	if identityFile == "" {
+		return nil, nil
+	}
+
+	return unitski.LoadIdentity(identityFile)
+}
+
+func printWarnings(warnings []string) {
+	for _, warning := range warnings {
+		log.Println("[warning] " + warning)
+	}
+}
+
+// confirm asks the user a yes/no question on stdin, defaulting to no.
+func confirm(question string) bool {
+	fmt.Print(question + " [y/N] ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"unitski-backup/unitski"
+)
+
+type configReport struct {
+	problems int
+}
+
+func (r *configReport) section(name string) {
+	fmt.Println(name + ":")
+}
+
+func (r *configReport) info(msg string) {
+	fmt.Println("  [info] " + msg)
+}
+
+func (r *configReport) ok(msg string) {
+	fmt.Println("  [ok] " + msg)
+}
+
+func (r *configReport) problem(msg string) {
+	r.problems++
+	fmt.Println("  [problem] " + msg)
+}
+
+// TestConfig checks the given config file & everything it references (containers, env variables, files).
+// All problems are printed as a report, an error is returned if any problem was found.
+func TestConfig(configFilePath string) error {
+	report := configReport{}
+	fmt.Println("Testing config: " + configFilePath)
+
+	// Load & validate the config itself
+	report.section("Config")
+	config, err := unitski.LoadConfig(configFilePath)
+	var configErr *unitski.ConfigError
+	if errors.As(err, &configErr) {
+		for _, problem := range configErr.Problems {
+			report.problem(problem)
+		}
+	} else if err != nil {
+		// Unable to read the config at all, nothing else to check
+		report.problem("Unable to load the config: " + err.Error())
+		return fmt.Errorf("config file %v can't be loaded", configFilePath)
+	} else {
+		report.ok("Config is valid")
+	}
+
+	// Check all containers & their variables
+	if len(config.Databases) > 0 {
+		cli, ctx := unitski.InitDocker()
+		for _, database := range config.Databases {
+			report.section("Database " + database.Name)
+			if !database.Enabled {
+				report.info("Is disabled (still checking)")
+			}
+
+			container, err := unitski.InspectDatabaseContainer(cli, ctx, database)
+			if err != nil {
+				report.problem(err.Error())
+				continue
+			}
+			report.ok("Container " + database.Container + " is running")
+
+			variables := map[string]unitski.BackupVariable{
+				"user":          database.User,
+				"password":      database.Password,
+				"database":      database.Database,
+				"auth-database": database.AuthDatabase,
+			}
+			for _, field := range []string{"user", "password", "database", "auth-database"} {
+				if _, err := unitski.ResolveContainerVariable(container, "", variables[field]); err != nil {
+					report.problem("Variable '" + field + "': " + err.Error())
+				} else {
+					report.ok("Variable '" + field + "' resolves")
+				}
+			}
+		}
+	}
+
+	// Check all files that should be backed up
+	for _, fileBackup := range config.Files {
+		report.section("Files " + fileBackup.Name)
+		if !fileBackup.Enabled {
+			report.info("Is disabled (still checking)")
+		}
+
+		for _, file := range fileBackup.Files {
+			if _, err := os.Stat(file); err != nil {
+				report.problem("Unable to stat " + file + ": " + err.Error())
+			} else {
+				report.ok("Found " + file)
+			}
+		}
+	}
+
+	if report.problems > 0 {
+		return fmt.Errorf("found %d problem(s) in config %v", report.problems, configFilePath)
+	}
+
+	fmt.Println("All good!")
+	return nil
+}
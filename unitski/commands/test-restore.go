@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"github.com/getsentry/sentry-go"
+	"log"
+	"strings"
+	"unitski-backup/unitski"
+)
+
+// TestRestore loads the latest backup of every MySQL/MariaDB database in the config (or only the given target)
+// into a throwaway container & runs its sanity query. Failures are reported to Sentry, an error is returned if any failed.
+func TestRestore(configFilePath string, target string, identityFile string) error {
+	// Load config
+	config, err := unitski.LoadConfig(configFilePath)
+	if err != nil {
+		return err
+	}
+	identity, err := loadIdentity(identityFile)
+	if err != nil {
+		return err
+	}
+
+	cli, ctx := unitski.InitDocker()
+	report := configReport{}
+	found := false
+	for _, database := range config.Databases {
+		if target != "" && database.Name != target {
+			continue
+		}
+		found = true
+
+		report.section(database.Name)
+		if database.Type != "" && database.Type != unitski.DbTypeMySql {
+			report.info("Skipped, test restores are only supported for MySQL/MariaDB databases")
+			continue
+		}
+		if !database.Enabled && target == "" {
+			report.info("Skipped, the database is disabled")
+			continue
+		}
+
+		// Find the latest backup & load it
+		backup, err := unitski.FindBackup(config.Folder+database.Name+"/", "")
+		if err != nil {
+			report.problem(err.Error())
+			sentry.CaptureException(err)
+			continue
+		}
+		report.info("Testing " + backup.Filename)
+
+		logger := log.New(log.Writer(), "  ["+database.Name+"] ", log.Flags()|log.Lmsgprefix)
+		output, err := unitski.TestRestoreDatabase(cli, ctx, database, backup.Path, identity, logger)
+		if err != nil {
+			report.problem("Test restore of " + backup.Filename + " failed: " + err.Error())
+			sentry.CaptureException(errors.New(database.Name + ": test restore of " + backup.Filename + " failed: " + err.Error()))
+			continue
+		}
+		report.ok("Restored " + backup.Filename + ", sanity query returned: " + strings.ReplaceAll(output, "\n", ", "))
+	}
+	if target != "" && !found {
+		return errors.New("No database found with name: " + target)
+	}
+
+	if report.problems > 0 {
+		return fmt.Errorf("%d test restore(s) failed", report.problems)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"github.com/getsentry/sentry-go"
+	"unitski-backup/unitski"
+)
+
+// Verify checks the integrity of all backups of every target in the config (or only the given target).
+// Encrypted backups are only fully checked if an identity file is given.
+// Every problem is printed & reported to Sentry, an error is returned if any problem was found.
+func Verify(configFilePath string, target string, identityFile string) error {
+	// Load config
+	config, err := unitski.LoadConfig(configFilePath)
+	if err != nil {
+		return err
+	}
+	identity, err := loadIdentity(identityFile)
+	if err != nil {
+		return err
+	}
+
+	// Collect the targets with the trailer their backups should end with
+	type verifyTarget struct {
+		name    string
+		trailer string
+	}
+	var targets []verifyTarget
+	for _, database := range config.Databases {
+		if target == "" || database.Name == target {
+			targets = append(targets, verifyTarget{database.Name, database.Type.DumpTrailer()})
+		}
+	}
+	for _, fileBackup := range config.Files {
+		if target == "" || fileBackup.Name == target {
+			targets = append(targets, verifyTarget{fileBackup.Name, ""})
+		}
+	}
+	if target != "" && len(targets) == 0 {
+		return errors.New("No database or files backup found with name: " + target)
+	}
+
+	// Verify each project folder
+	report := configReport{}
+	for _, verify := range targets {
+		projectFolder := config.Folder + verify.name + "/"
+		report.section(verify.name)
+
+		problems, err := unitski.VerifyProjectFolder(projectFolder, verify.trailer, identity)
+		if err != nil {
+			problems = append(problems, "Unable to read "+projectFolder+": "+err.Error())
+		}
+		for _, problem := range problems {
+			report.problem(problem)
+			sentry.CaptureException(errors.New(verify.name + ": " + problem))
+		}
+		if len(problems) == 0 {
+			report.ok("All backups are intact")
+		}
+	}
+
+	if report.problems > 0 {
+		return fmt.Errorf("found %d problem(s) in the backups", report.problems)
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package unitski
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+type CompressionAlgorithm string
+
+const (
+	CompressionNone  CompressionAlgorithm = "none"
+	CompressionGzip  CompressionAlgorithm = "gzip"
+	CompressionZstd  CompressionAlgorithm = "zstd"
+	CompressionXz    CompressionAlgorithm = "xz"
+	CompressionBzip2 CompressionAlgorithm = "bzip2"
+)
+
+// xzDictionarySizes maps the xz compression levels (0-9) on the dictionary size that `xz` uses for them.
+var xzDictionarySizes = []int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// GetCompression returns the configured compression, defaulting to gzip.
+func (config BackupConfigDatabase) GetCompression() BackupCompression {
+	if config.Compression.Algorithm == "" {
+		return BackupCompression{CompressionGzip, config.Compression.Level}
+	}
+
+	return config.Compression
+}
+
+// GetCompression returns the configured compression, falling back on gzip/none based on the 'compress' flag.
+func (config BackupConfigFiles) GetCompression() BackupCompression {
+	if config.Compression.Algorithm == "" {
+		if config.Compress {
+			return BackupCompression{CompressionGzip, config.Compression.Level}
+		}
+		return BackupCompression{Algorithm: CompressionNone}
+	}
+
+	return config.Compression
+}
+
+// Extension returns the file extension that is added to compressed files.
+func (c BackupCompression) Extension() string {
+	switch c.Algorithm {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	case CompressionXz:
+		return ".xz"
+	case CompressionBzip2:
+		return ".bz2"
+	default:
+		return ""
+	}
+}
+
+// levelRange returns the min, max & default level of the algorithm.
+func (c BackupCompression) levelRange() (min int, max int, defaultLevel int) {
+	switch c.Algorithm {
+	case CompressionGzip:
+		return gzip.BestSpeed, gzip.BestCompression, gzip.BestCompression
+	case CompressionZstd:
+		return 1, 22, 3
+	case CompressionXz:
+		return 0, len(xzDictionarySizes) - 1, 6
+	case CompressionBzip2:
+		return 1, 9, 9
+	default:
+		return 0, 0, 0
+	}
+}
+
+// GetLevel returns the configured level, or the default level of the algorithm if not set.
+func (c BackupCompression) GetLevel() int {
+	if _, _, defaultLevel := c.levelRange(); c.Level == nil {
+		return defaultLevel
+	}
+
+	return *c.Level
+}
+
+// Validate checks whether the algorithm is known & the level is within range.
+func (c BackupCompression) Validate() error {
+	switch c.Algorithm {
+	case "", CompressionNone, CompressionGzip, CompressionZstd, CompressionXz, CompressionBzip2:
+		// Known algorithms
+	default:
+		return &FileError{"Unknown compression algorithm: " + string(c.Algorithm)}
+	}
+
+	if min, max, _ := c.levelRange(); c.Level != nil && (*c.Level < min || *c.Level > max) {
+		return &FileError{"Compression level of " + string(c.Algorithm) + " should be between " + strconv.Itoa(min) + " and " + strconv.Itoa(max)}
+	}
+
+	return nil
+}
+
+// NewWriter wraps the given writer in a compressor. Closing the compressor doesn't close the given writer.
+func (c BackupCompression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c.Algorithm {
+	case CompressionGzip:
+		return gzip.NewWriterLevel(w, c.GetLevel())
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.GetLevel())))
+	case CompressionXz:
+		return xz.WriterConfig{DictCap: xzDictionarySizes[c.GetLevel()]}.NewWriter(w)
+	case CompressionBzip2:
+		return newBzip2Writer(w, c.GetLevel())
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, c.Validate()
+	}
+}
+
+// CompressionOfFile determines the compression algorithm of a (possibly encrypted) file based on its extension.
+func CompressionOfFile(filename string) BackupCompression {
+	filename = withoutEncryptionSuffix(filename)
+	for _, algorithm := range []CompressionAlgorithm{CompressionGzip, CompressionZstd, CompressionXz, CompressionBzip2} {
+		compression := BackupCompression{Algorithm: algorithm}
+		if strings.HasSuffix(filename, compression.Extension()) {
+			return compression
+		}
+	}
+
+	return BackupCompression{Algorithm: CompressionNone}
+}
+
+// NewReader wraps the given reader in a decompressor. Closing the decompressor doesn't close the given reader.
+func (c BackupCompression) NewReader(r io.Reader) (io.ReadCloser, error) {
+	switch c.Algorithm {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case CompressionXz:
+		reader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(reader), nil
+	case CompressionBzip2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	default:
+		return nil, c.Validate()
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// bzip2Writer streams everything through the `bzip2` binary, as Go only ships a bzip2 reader.
+type bzip2Writer struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+func newBzip2Writer(w io.Writer, level int) (*bzip2Writer, error) {
+	cmd := exec.Command("bzip2", "-c", "-"+strconv.Itoa(level))
+	cmd.Stdout = w
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &bzip2Writer{cmd, stdin}, nil
+}
+
+func (b *bzip2Writer) Write(p []byte) (int, error) {
+	return b.stdin.Write(p)
+}
+
+func (b *bzip2Writer) Close() error {
+	if err := b.stdin.Close(); err != nil {
+		_ = b.cmd.Wait()
+		return err
+	}
+
+	return b.cmd.Wait()
+}
@@ -5,39 +5,80 @@ import (
 	"io/ioutil"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
 )
 
 type BackupConfig struct {
-	Folder     string                 `json:"folder"`
-	SyncFolder string                 `json:"sync-folder"`
-	Databases  []BackupConfigDatabase `json:"databases"`
-	Files      []BackupConfigFiles    `json:"files"`
+	Folder       string                 `json:"folder"`
+	SyncFolder   string                 `json:"sync-folder"`
+	MinFreeSpace string                 `json:"min-free-space"`
+	Concurrency  int                    `json:"concurrency"`
+	Encryption   BackupEncryption       `json:"encryption"` // Default for all targets without their own encryption
+	Quota        string                 `json:"quota"`      // Max total size of all backups in the folder (optional)
+	Databases    []BackupConfigDatabase `json:"databases"`
+	Files        []BackupConfigFiles    `json:"files"`
 }
 
 type BackupConfigDatabase struct {
 	Name      string         `json:"name"`
 	Enabled   bool           `json:"enabled"`
+	Type      DatabaseType   `json:"type"`
 	Interval  BackupInterval `json:"interval"`
 	Container string         `json:"container"`
 	User      BackupVariable `json:"user"`
 	Password  BackupVariable `json:"password"`
 	Database  BackupVariable `json:"database"`
+
+	Compression BackupCompression `json:"compression"`
+	Encryption  BackupEncryption  `json:"encryption"`
+	Retention   BackupRetention   `json:"retention"`
+	OnLowSpace  LowSpacePolicy    `json:"on-low-space"`
+
+	// AuthDatabase is only used by MongoDB (defaults to 'admin')
+	AuthDatabase BackupVariable `json:"auth-database"`
+
+	// TestRestore is only used by MySQL/MariaDB
+	TestRestore BackupTestRestore `json:"test-restore"`
+
+	RotateSyncedMonthlyBackups bool `json:"rotate-synced-monthly-backups"`
 }
 
 type BackupConfigFiles struct {
-	Name                       string         `json:"name"`
-	Enabled                    bool           `json:"enabled"`
-	Interval                   BackupInterval `json:"interval"`
-	Files                      []string       `json:"files"`
-	Exclude                    []string       `json:"exclude"`
-	Compress                   bool           `json:"compress"`
-	RotateSyncedMonthlyBackups bool           `json:"rotate-synced-monthly-backups"`
+	Name                       string            `json:"name"`
+	Enabled                    bool              `json:"enabled"`
+	Interval                   BackupInterval    `json:"interval"`
+	Files                      []string          `json:"files"`
+	Exclude                    []string          `json:"exclude"`
+	Compress                   bool              `json:"compress"`
+	Compression                BackupCompression `json:"compression"`
+	Encryption                 BackupEncryption  `json:"encryption"`
+	Retention                  BackupRetention   `json:"retention"`
+	OnLowSpace                 LowSpacePolicy    `json:"on-low-space"`
+	RotateSyncedMonthlyBackups bool              `json:"rotate-synced-monthly-backups"`
+}
+
+type BackupTestRestore struct {
+	Image string `json:"image"` // Defaults to the image of the database container
+	Query string `json:"query"` // Defaults to 'SHOW TABLES'
 }
 
 type BackupInterval struct {
+	Hourly  int `json:"hourly"` // Adds the time to the names of the backup files
 	Daily   int `json:"daily"`
 	Weekly  int `json:"weekly"`
 	Monthly int `json:"monthly"`
+	Yearly  int `json:"yearly"`
+
+	WeeklyOn  string            `json:"weekly-on"`  // Day of the week of weekly backups (default: monday)
+	MonthlyOn DayOfMonth        `json:"monthly-on"` // Day of the month of monthly backups, 1-31 or "last" (default: 1)
+	Schedule  map[string]string `json:"schedule"`   // Cron-like "[hour] day-of-month month day-of-week" per tier, overrides the days above
+}
+
+// BackupCompression configures the compression of the backup files, the level is optional (unset = default of the algorithm).
+type BackupCompression struct {
+	Algorithm CompressionAlgorithm `json:"algorithm"`
+	Level     *int                 `json:"level"`
 }
 
 type BackupVariableType string
@@ -52,36 +93,112 @@ type BackupVariable struct {
 	Value   string             `json:"value"`
 }
 
-func LoadConfig(path string) BackupConfig {
-	config := loadFromFile(path)
-	validate(config)
+// Keep returns the number of backups that are kept in the tier folder, 0 if the tier isn't used.
+func (interval BackupInterval) Keep(tier string) int {
+	switch tier {
+	case hourlyDir:
+		return interval.Hourly
+	case dailyDir:
+		return interval.Daily
+	case weeklyDir:
+		return interval.Weekly
+	case monthlyDir:
+		return interval.Monthly
+	case yearlyDir:
+		return interval.Yearly
+	default:
+		return 0
+	}
+}
+
+// Any checks whether any of the tiers is used.
+func (interval BackupInterval) Any() bool {
+	for _, tier := range backupTiers {
+		if interval.Keep(tier) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetQuota returns the max total size of all backups in the folder, 0 if there's no quota.
+func (config BackupConfig) GetQuota() (int64, error) {
+	if config.Quota == "" {
+		return 0, nil
+	}
+
+	return ParseSize(config.Quota)
+}
+
+// GetConcurrency returns the number of backups that may run in parallel, defaults to 1.
+func (config BackupConfig) GetConcurrency() int {
+	if config.Concurrency <= 0 {
+		return 1
+	}
+
+	return config.Concurrency
+}
+
+// ConfigError contains all problems that were found while validating a config file.
+type ConfigError struct {
+	Problems []string
+}
+
+func (error *ConfigError) Error() string {
+	return "Found " + strconv.Itoa(len(error.Problems)) + " problem(s) in the config:\n- " + strings.Join(error.Problems, "\n- ")
+}
+
+// LoadConfig reads the config file & validates it.
+// Returns the parsed config, even if it contains problems. Those problems are returned as a ConfigError.
+func LoadConfig(path string) (BackupConfig, error) {
+	config, err := loadFromFile(path)
+	if err != nil {
+		return config, err
+	}
 
-	return config
+	// Targets without their own encryption use the default one
+	for i := range config.Databases {
+		if !config.Databases[i].Encryption.Enabled() {
+			config.Databases[i].Encryption = config.Encryption
+		}
+	}
+	for i := range config.Files {
+		if !config.Files[i].Encryption.Enabled() {
+			config.Files[i].Encryption = config.Encryption
+		}
+	}
+
+	if problems := validate(config); len(problems) > 0 {
+		return config, &ConfigError{problems}
+	}
+
+	return config, nil
 }
 
-func loadFromFile(path string) BackupConfig {
+func loadFromFile(path string) (BackupConfig, error) {
+	var config BackupConfig
+
 	jsonFile, err := os.Open(path)
 	if err != nil {
-		panic(err)
+		return config, err
 	}
 	defer jsonFile.Close()
 
-	var config BackupConfig
 	byteValue, err := ioutil.ReadAll(jsonFile)
-	err = json.Unmarshal(byteValue, &config)
 	if err != nil {
-		panic(err)
+		return config, err
+	}
+	if err = json.Unmarshal(byteValue, &config); err != nil {
+		return config, err
 	}
 
-	return config
+	return config, nil
 }
 
-func validate(config BackupConfig) {
+func validate(config BackupConfig) (problems []string) {
 	// Build a regex that makes sure the project names are path-safe
-	allowedNameFormat, regErr := regexp.Compile("^[a-z0-9\\-_]+$")
-	if regErr != nil {
-		panic(regErr)
-	}
+	allowedNameFormat := regexp.MustCompile("^[a-z0-9\\-_]+$")
 
 	// Build a map of all known names
 	knownNames := map[string]bool{}
@@ -89,36 +206,170 @@ func validate(config BackupConfig) {
 	// Check if all names are unique & are folder/path-safe
 	// => Database
 	for _, database := range config.Databases {
-		checkName(allowedNameFormat, knownNames, database.Name)
+		problems = append(problems, checkName(allowedNameFormat, knownNames, database.Name)...)
 		knownNames[database.Name] = true
+
+		problems = append(problems, checkInterval(database.Name, database.Interval)...)
+		problems = append(problems, checkRetention(database.Name, database.Retention)...)
+		switch database.Type {
+		case "", DbTypeMySql, DbTypePostgres, DbTypeMongo, DbTypeRedis:
+			// Supported types
+		case DbTypeSqlite:
+			if database.Database.Value == "" {
+				problems = append(problems, "SQLite database '"+database.Name+"' requires the path of the database file as 'database'")
+			}
+		default:
+			problems = append(problems, "Database '"+database.Name+"' has an unknown type: "+string(database.Type))
+		}
+		if database.Container == "" {
+			problems = append(problems, "Database '"+database.Name+"' has no container")
+		}
+		problems = append(problems, checkVariable(database.Name, "user", database.User)...)
+		problems = append(problems, checkVariable(database.Name, "password", database.Password)...)
+		problems = append(problems, checkVariable(database.Name, "database", database.Database)...)
+		problems = append(problems, checkVariable(database.Name, "auth-database", database.AuthDatabase)...)
+		if err := database.Encryption.Validate(); err != nil {
+			problems = append(problems, "Database '"+database.Name+"': "+err.Error())
+		}
+		if err := database.Compression.Validate(); err != nil {
+			problems = append(problems, "Database '"+database.Name+"': "+err.Error())
+		}
+		problems = append(problems, checkLowSpacePolicy(database.Name, database.OnLowSpace)...)
 	}
 
 	// => Other
 	for _, fileBackup := range config.Files {
-		checkName(allowedNameFormat, knownNames, fileBackup.Name)
+		problems = append(problems, checkName(allowedNameFormat, knownNames, fileBackup.Name)...)
 		knownNames[fileBackup.Name] = true
+
+		problems = append(problems, checkInterval(fileBackup.Name, fileBackup.Interval)...)
+		problems = append(problems, checkRetention(fileBackup.Name, fileBackup.Retention)...)
+		if len(fileBackup.Files) == 0 {
+			problems = append(problems, "Files backup '"+fileBackup.Name+"' has no files to backup")
+		}
+		if _, err := NewExcludeMatcher(fileBackup.Exclude); err != nil {
+			problems = append(problems, "Files backup '"+fileBackup.Name+"': "+err.Error())
+		}
+		if err := fileBackup.Encryption.Validate(); err != nil {
+			problems = append(problems, "Files backup '"+fileBackup.Name+"': "+err.Error())
+		}
+		if err := fileBackup.Compression.Validate(); err != nil {
+			problems = append(problems, "Files backup '"+fileBackup.Name+"': "+err.Error())
+		}
+		problems = append(problems, checkLowSpacePolicy(fileBackup.Name, fileBackup.OnLowSpace)...)
 	}
 
 	// Check if the target folder exists, is writable, is an absolute path & has trailing /
 	folder := config.Folder
 	if matched, _ := regexp.MatchString("^/.+/$", folder); !matched {
-		panic("Folder should be an absolute path with trailing slash, this isn't: " + folder)
-	}
-	if stat, dirErr := os.Stat(folder); os.IsNotExist(dirErr) {
-		panic("The backup folder doesn't exist: " + folder)
+		problems = append(problems, "Folder should be an absolute path with trailing slash, this isn't: "+folder)
+	} else if stat, dirErr := os.Stat(folder); os.IsNotExist(dirErr) {
+		problems = append(problems, "The backup folder doesn't exist: "+folder)
+	} else if dirErr != nil {
+		problems = append(problems, "Unable to check the backup folder: "+dirErr.Error())
 	} else if !stat.IsDir() {
-		panic("Backup 'folder' isn't a folder: " + folder)
+		problems = append(problems, "Backup 'folder' isn't a folder: "+folder)
+	}
+
+	if config.Concurrency < 0 {
+		problems = append(problems, "Concurrency can't be negative")
 	}
+	if _, err := ParseMinFreeSpace(config.MinFreeSpace); err != nil {
+		problems = append(problems, "Invalid 'min-free-space': "+err.Error())
+	}
+	if _, err := config.GetQuota(); err != nil {
+		problems = append(problems, "Invalid 'quota': "+err.Error())
+	}
+
+	// The sync folder is optional, but should follow the same rules if set
+	if syncFolder := config.SyncFolder; syncFolder != "" {
+		if matched, _ := regexp.MatchString("^/.+/$", syncFolder); !matched {
+			problems = append(problems, "Sync folder should be an absolute path with trailing slash, this isn't: "+syncFolder)
+		} else if syncFolder == folder {
+			problems = append(problems, "Sync folder can't be the same as the backup folder: "+syncFolder)
+		} else if stat, dirErr := os.Stat(syncFolder); os.IsNotExist(dirErr) {
+			problems = append(problems, "The sync folder doesn't exist: "+syncFolder)
+		} else if dirErr != nil {
+			problems = append(problems, "Unable to check the sync folder: "+dirErr.Error())
+		} else if !stat.IsDir() {
+			problems = append(problems, "Sync 'sync-folder' isn't a folder: "+syncFolder)
+		}
+	}
+
+	return problems
 }
 
-func checkName(allowedNameFormat *regexp.Regexp, knownNames map[string]bool, name string) {
+func checkName(allowedNameFormat *regexp.Regexp, knownNames map[string]bool, name string) (problems []string) {
 	// => Unique
 	if _, ok := knownNames[name]; ok {
-		panic("Found duplicate project name entry: " + name + " | All names need to be unique!")
+		problems = append(problems, "Found duplicate project name entry: "+name+" | All names need to be unique!")
 	}
 
 	// => Path-safe
 	if !allowedNameFormat.MatchString(name) {
-		panic("A project name needs to be lowercase & path-safe (a-z0-9-_), this isn't: " + name)
+		problems = append(problems, "A project name needs to be lowercase & path-safe (a-z0-9-_), this isn't: "+name)
+	}
+
+	return problems
+}
+
+func checkInterval(name string, interval BackupInterval) (problems []string) {
+	for _, tier := range backupTiers {
+		if interval.Keep(tier) < 0 {
+			problems = append(problems, "Intervals of '"+name+"' can't be negative")
+			break
+		}
 	}
+	if !interval.Any() {
+		problems = append(problems, "All intervals of '"+name+"' are 0, this backup will never run!")
+	}
+	for tier := range interval.Schedule {
+		if !isBackupTier(tier + "/") {
+			problems = append(problems, "Schedule of '"+name+"' has an unknown tier: "+tier)
+		}
+	}
+	for _, tier := range backupTiers {
+		if _, err := interval.GetSchedule(tier); err != nil {
+			problems = append(problems, "Interval of '"+name+"': "+err.Error())
+		}
+	}
+
+	return problems
+}
+
+func checkLowSpacePolicy(name string, policy LowSpacePolicy) (problems []string) {
+	switch policy {
+	case "", LowSpaceSkip, LowSpaceWarn, LowSpaceFail:
+		// Valid policies
+	default:
+		problems = append(problems, "Unknown 'on-low-space' policy of '"+name+"': "+string(policy))
+	}
+
+	return problems
+}
+
+func checkRetention(name string, retention BackupRetention) (problems []string) {
+	for tier := range retention.MaxAge {
+		if !isBackupTier(tier + "/") {
+			problems = append(problems, "Max age of '"+name+"' has an unknown tier: "+tier)
+		} else if _, err := retention.GetMaxAge(tier); err != nil {
+			problems = append(problems, "Max age of '"+name+"': "+err.Error())
+		}
+	}
+	if _, err := retention.GetMaxSize(); err != nil {
+		problems = append(problems, "Invalid 'max-size' of '"+name+"': "+err.Error())
+	}
+
+	return problems
+}
+
+func checkVariable(name string, field string, variable BackupVariable) (problems []string) {
+	switch variable.VarType {
+	case "", VarTypeConstant, VarTypeDockerEnv:
+		// Valid types
+	default:
+		problems = append(problems, "Variable '"+field+"' of '"+name+"' has an unknown type: "+string(variable.VarType))
+	}
+
+	return problems
 }
@@ -0,0 +1,398 @@
+package unitski
+
+import (
+	"context"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type DatabaseType string
+
+const (
+	DbTypeMySql    DatabaseType = "mysql"
+	DbTypePostgres DatabaseType = "postgres"
+	DbTypeMongo    DatabaseType = "mongo"
+	DbTypeRedis    DatabaseType = "redis"
+	DbTypeSqlite   DatabaseType = "sqlite"
+)
+
+// redisSaveTimeout is the max time to wait on a BGSAVE of Redis to complete
+const redisSaveTimeout = 30 * time.Minute
+
+// DumpExtension returns the extension (without compression) of the dump files for the type of database.
+func (t DatabaseType) DumpExtension() string {
+	switch t {
+	case DbTypeMongo:
+		return ".archive"
+	case DbTypeRedis:
+		return ".rdb"
+	case DbTypeSqlite:
+		return ".sqlite"
+	default:
+		return ".sql"
+	}
+}
+
+// DumpTrailer returns the text a complete dump of the type of database ends with, empty if there is none.
+func (t DatabaseType) DumpTrailer() string {
+	switch t {
+	case "", DbTypeMySql:
+		return "-- Dump completed"
+	case DbTypePostgres:
+		// Both pg_dump & pg_dumpall ("database cluster dump complete")
+		return " dump complete"
+	default:
+		return ""
+	}
+}
+
+// DumpDatabase dumps the database from the docker container using the strategy that matches the type of database.
+// The dump is streamed through the configured compression into the given file, nothing is left behind if it fails.
+// Any (error) output of the dump tools is logged to the given logger.
+func DumpDatabase(cli *client.Client, ctx context.Context, config BackupConfigDatabase, dumpToFile string, logger *log.Logger) error {
+	artifact, err := CreateArtifact(dumpToFile, config.GetCompression(), config.Encryption)
+	if err != nil {
+		return err
+	}
+
+	switch config.Type {
+	case "", DbTypeMySql:
+		err = DumpMySqlDatabase(cli, ctx, config, artifact, logger)
+	case DbTypePostgres:
+		err = DumpPostgresDatabase(cli, ctx, config, artifact, logger)
+	case DbTypeMongo:
+		err = DumpMongoDatabase(cli, ctx, config, artifact, logger)
+	case DbTypeRedis:
+		err = DumpRedisDatabase(cli, ctx, config, artifact, logger)
+	case DbTypeSqlite:
+		err = DumpSqliteDatabase(cli, ctx, config, artifact, logger)
+	default:
+		err = &DockerError{"Unknown database type '" + string(config.Type) + "' for db: " + config.Name}
+	}
+
+	if err != nil {
+		artifact.Abort()
+		return err
+	}
+
+	return artifact.Close()
+}
+
+// databaseCredentials are the resolved variables that are needed to connect to a database.
+type databaseCredentials struct {
+	user     string
+	password string
+	database string
+}
+
+// mySqlCredentials resolves the credentials for a MySQL/MariaDB container, user defaults to root.
+func mySqlCredentials(container types.ContainerJSON, config BackupConfigDatabase) (credentials databaseCredentials, err error) {
+	if credentials.database, err = ResolveContainerVariable(container, "", config.Database); err != nil {
+		return credentials, err
+	}
+	if credentials.user, err = ResolveContainerVariable(container, "root", config.User); err != nil {
+		return credentials, err
+	}
+	credentials.password, err = ResolveContainerVariable(container, "", config.Password)
+
+	return credentials, err
+}
+
+// mySqlEnv returns the env that passes the password to the MySQL clients.
+func (c databaseCredentials) mySqlEnv() map[string]string {
+	env := map[string]string{}
+	if c.password != "" {
+		env["MYSQL_PWD"] = c.password
+	}
+	return env
+}
+
+// postgresCredentials resolves the credentials for a PostgreSQL container.
+// Unset variables fall back on the POSTGRES_USER, POSTGRES_PASSWORD & POSTGRES_DB env of the container.
+func postgresCredentials(container types.ContainerJSON, config BackupConfigDatabase) (credentials databaseCredentials, err error) {
+	if credentials.database, err = resolveWithEnvFallback(container, config.Database, "POSTGRES_DB", ""); err != nil {
+		return credentials, err
+	}
+	if credentials.user, err = resolveWithEnvFallback(container, config.User, "POSTGRES_USER", "postgres"); err != nil {
+		return credentials, err
+	}
+	credentials.password, err = resolveWithEnvFallback(container, config.Password, "POSTGRES_PASSWORD", "")
+
+	return credentials, err
+}
+
+// postgresEnv returns the env that passes the password to the PostgreSQL clients.
+func (c databaseCredentials) postgresEnv() map[string]string {
+	env := map[string]string{}
+	if c.password != "" {
+		env["PGPASSWORD"] = c.password
+	}
+	return env
+}
+
+// DumpMySqlDatabase dumps the database from a docker container that is running MySQL/MariaDB
+func DumpMySqlDatabase(cli *client.Client, ctx context.Context, config BackupConfigDatabase, dumpTo io.Writer, logger *log.Logger) error {
+	// Get all information about the container
+	container, err := InspectDatabaseContainer(cli, ctx, config)
+	if err != nil {
+		return err
+	}
+
+	// Determine the required mysqldump variables
+	credentials, err := mySqlCredentials(container, config)
+	if err != nil {
+		return err
+	}
+
+	// Attempt to dump the database, the password is passed through the env
+	return runInContainer(cli, ctx, logger, config.Container, credentials.mySqlEnv(), []string{
+		"mysqldump",
+		"-u",
+		credentials.user,
+		credentials.database,
+	}, nil, dumpTo)
+}
+
+// DumpPostgresDatabase dumps the database from a docker container that is running PostgreSQL.
+// Uses `pg_dump` if a database is known, otherwise all databases are dumped using `pg_dumpall`.
+func DumpPostgresDatabase(cli *client.Client, ctx context.Context, config BackupConfigDatabase, dumpTo io.Writer, logger *log.Logger) error {
+	// Get all information about the container
+	container, err := InspectDatabaseContainer(cli, ctx, config)
+	if err != nil {
+		return err
+	}
+
+	// Determine the required pg_dump variables
+	credentials, err := postgresCredentials(container, config)
+	if err != nil {
+		return err
+	}
+
+	// Dump a single database or all of them, the password is passed through the env
+	command := []string{"pg_dumpall", "-U", credentials.user}
+	if credentials.database != "" {
+		command = []string{"pg_dump", "-U", credentials.user, credentials.database}
+	}
+
+	return runInContainer(cli, ctx, logger, config.Container, credentials.postgresEnv(), command, nil, dumpTo)
+}
+
+// EstimateDatabaseSize asks the database for the number of bytes it uses, as an indication of the size of the dump.
+// Only supported for MySQL/MariaDB (information_schema) & PostgreSQL (pg_database_size), returns false for other types.
+func EstimateDatabaseSize(cli *client.Client, ctx context.Context, config BackupConfigDatabase, logger *log.Logger) (size int64, supported bool, err error) {
+	var credentials databaseCredentials
+	var env map[string]string
+	var command []string
+
+	container, err := InspectDatabaseContainer(cli, ctx, config)
+	if err != nil {
+		return 0, true, err
+	}
+
+	switch config.Type {
+	case "", DbTypeMySql:
+		if credentials, err = mySqlCredentials(container, config); err != nil {
+			return 0, true, err
+		}
+		query := "SELECT COALESCE(SUM(data_length + index_length), 0) FROM information_schema.tables"
+		if credentials.database != "" {
+			query += " WHERE table_schema = " + quoteSqlString(credentials.database)
+		}
+		env = credentials.mySqlEnv()
+		command = []string{"mysql", "-u", credentials.user, "-N", "-B", "-e", query}
+	case DbTypePostgres:
+		if credentials, err = postgresCredentials(container, config); err != nil {
+			return 0, true, err
+		}
+		query := "SELECT COALESCE(SUM(pg_database_size(datname)), 0) FROM pg_database"
+		if credentials.database != "" {
+			query = "SELECT pg_database_size(" + quoteSqlString(credentials.database) + ")"
+		}
+		env = credentials.postgresEnv()
+		command = []string{"psql", "-U", credentials.user, "-d", "postgres", "-t", "-A", "-c", query}
+	default:
+		return 0, false, nil
+	}
+
+	output, err := execInContainer(cli, ctx, logger, config.Container, env, command)
+	if err != nil {
+		return 0, true, err
+	}
+	if size, err = strconv.ParseInt(output, 10, 64); err != nil {
+		return 0, true, &DockerError{"Unexpected database size of " + config.Name + ": " + output}
+	}
+
+	return size, true, nil
+}
+
+// quoteSqlString quotes the value as a SQL string literal.
+func quoteSqlString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// mongoCommand builds a shell command for one of the MongoDB tools, including the authentication arguments.
+// Unset credentials fall back on the MONGO_INITDB_ROOT_USERNAME & MONGO_INITDB_ROOT_PASSWORD env of the container.
+// The credentials are passed through the env, the password ends up in a temporary config file in the container
+// (removed once the tool exits) so that it never shows up in the process list. Requires the database tools 100.3+.
+func mongoCommand(container types.ContainerJSON, config BackupConfigDatabase, tool string) (database string, env map[string]string, command string, err error) {
+	if database, err = ResolveContainerVariable(container, "", config.Database); err != nil {
+		return
+	}
+	user, err := resolveWithEnvFallback(container, config.User, "MONGO_INITDB_ROOT_USERNAME", "")
+	if err != nil {
+		return
+	}
+	password, err := resolveWithEnvFallback(container, config.Password, "MONGO_INITDB_ROOT_PASSWORD", "")
+	if err != nil {
+		return
+	}
+	authDatabase, err := ResolveContainerVariable(container, "admin", config.AuthDatabase)
+	if err != nil {
+		return
+	}
+
+	env = map[string]string{}
+	command = "exec " + tool + " --archive --quiet"
+	if user != "" {
+		// A single quoted YAML string only needs its quotes doubled
+		env["UNITSKI_MONGO_CONFIG"] = "password: '" + strings.ReplaceAll(password, "'", "''") + "'\n"
+		env["UNITSKI_MONGO_USER"] = user
+		env["UNITSKI_MONGO_AUTH_DB"] = authDatabase
+
+		// printf is a shell builtin, the config file is only readable by the user of the tool
+		command = "umask 077 && config=$(mktemp) && trap 'rm -f \"$config\"' EXIT && " +
+			"printf '%s' \"$UNITSKI_MONGO_CONFIG\" > \"$config\" && " +
+			tool + " --archive --quiet --config=\"$config\" --username=\"$UNITSKI_MONGO_USER\" --authenticationDatabase=\"$UNITSKI_MONGO_AUTH_DB\""
+	}
+
+	return database, env, command, nil
+}
+
+// DumpMongoDatabase dumps the database(s) from a docker container that is running MongoDB into a `mongodump` archive.
+// All databases are dumped unless a database is set.
+func DumpMongoDatabase(cli *client.Client, ctx context.Context, config BackupConfigDatabase, dumpTo io.Writer, logger *log.Logger) error {
+	// Get all information about the container
+	container, err := InspectDatabaseContainer(cli, ctx, config)
+	if err != nil {
+		return err
+	}
+
+	// Build the mongodump command, the archive is written to stdout
+	database, env, command, err := mongoCommand(container, config, "mongodump")
+	if err != nil {
+		return err
+	}
+	if database != "" {
+		env["UNITSKI_MONGO_DB"] = database
+		command += " --db=\"$UNITSKI_MONGO_DB\""
+	}
+
+	return runInContainer(cli, ctx, logger, config.Container, env, []string{"sh", "-c", command}, nil, dumpTo)
+}
+
+// DumpRedisDatabase snapshots a docker container that is running Redis.
+// Triggers a BGSAVE, waits for it to complete & copies the resulting RDB file out of the container.
+// An unset password falls back on the REDIS_PASSWORD env of the container.
+func DumpRedisDatabase(cli *client.Client, ctx context.Context, config BackupConfigDatabase, dumpTo io.Writer, logger *log.Logger) error {
+	// Get all information about the container
+	container, err := InspectDatabaseContainer(cli, ctx, config)
+	if err != nil {
+		return err
+	}
+
+	// The password is passed through the env, which redis-cli picks up
+	password, err := resolveWithEnvFallback(container, config.Password, "REDIS_PASSWORD", "")
+	if err != nil {
+		return err
+	}
+	env := map[string]string{}
+	if password != "" {
+		env["REDISCLI_AUTH"] = password
+	}
+	redisCli := func(arguments ...string) (string, error) {
+		return execInContainer(cli, ctx, logger, config.Container, env, append([]string{"redis-cli"}, arguments...))
+	}
+
+	// Trigger the background save, a new LASTSAVE timestamp indicates that it has completed
+	lastSave, err := redisCli("LASTSAVE")
+	if err != nil {
+		return err
+	}
+	if output, err := redisCli("BGSAVE"); err != nil {
+		return err
+	} else if !strings.Contains(output, "Background saving started") && !strings.Contains(output, "already in progress") {
+		return &DockerError{"Redis refused to start a BGSAVE (db: " + config.Name + "): " + output}
+	}
+
+	deadline := time.Now().Add(redisSaveTimeout)
+	for {
+		time.Sleep(time.Second)
+		if currentSave, err := redisCli("LASTSAVE"); err != nil {
+			return err
+		} else if currentSave != lastSave {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return &DockerError{"Timed out waiting on the BGSAVE of Redis (db: " + config.Name + ")"}
+		}
+	}
+
+	// Make sure the save actually succeeded
+	if info, err := redisCli("INFO", "persistence"); err != nil {
+		return err
+	} else if !strings.Contains(info, "rdb_last_bgsave_status:ok") {
+		return &DockerError{"The BGSAVE of Redis failed (db: " + config.Name + ")"}
+	}
+
+	// Find the RDB file
+	dir, err := redisCli("--raw", "CONFIG", "GET", "dir")
+	if err != nil {
+		return err
+	}
+	dbFilename, err := redisCli("--raw", "CONFIG", "GET", "dbfilename")
+	if err != nil {
+		return err
+	}
+	dirLines, filenameLines := strings.Split(dir, "\n"), strings.Split(dbFilename, "\n")
+	if len(dirLines) != 2 || len(filenameLines) != 2 {
+		return &DockerError{"Unable to determine the location of the RDB file of Redis (db: " + config.Name + ")"}
+	}
+	rdbFile := strings.TrimSpace(dirLines[1]) + "/" + strings.TrimSpace(filenameLines[1])
+
+	// Copy it out of the container
+	return runInContainer(cli, ctx, logger, config.Container, nil, []string{"cat", rdbFile}, nil, dumpTo)
+}
+
+// DumpSqliteDatabase copies a SQLite database file that lives inside a docker container.
+// The 'database' variable should be the path of the database file within the container, which needs `sqlite3` installed.
+// Uses the `.backup` command of sqlite3 to get a consistent copy, even while the database is in use.
+func DumpSqliteDatabase(cli *client.Client, ctx context.Context, config BackupConfigDatabase, dumpTo io.Writer, logger *log.Logger) error {
+	// Get all information about the container
+	container, err := InspectDatabaseContainer(cli, ctx, config)
+	if err != nil {
+		return err
+	}
+
+	database, err := ResolveContainerVariable(container, "", config.Database)
+	if err != nil {
+		return err
+	} else if database == "" {
+		return &DockerError{"No database file set for SQLite (db: " + config.Name + ")"}
+	}
+
+	// Create the backup in a temporary file, stream that file & clean it up afterwards
+	env := map[string]string{
+		"UNITSKI_SQLITE_DB":  database,
+		"UNITSKI_SQLITE_TMP": "/tmp/unitski-backup-" + config.Name + ".sqlite",
+	}
+	command := "sqlite3 \"$UNITSKI_SQLITE_DB\" \".backup '$UNITSKI_SQLITE_TMP'\" && cat \"$UNITSKI_SQLITE_TMP\"; " +
+		"status=$?; rm -f \"$UNITSKI_SQLITE_TMP\"; exit $status"
+
+	return runInContainer(cli, ctx, logger, config.Container, env, []string{"sh", "-c", command}, nil, dumpTo)
+}
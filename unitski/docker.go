@@ -1,13 +1,17 @@
 package unitski
 
 import (
-	"bufio"
+	"bytes"
 	"context"
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"io"
 	"log"
-	"os"
-	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type DockerError struct {
@@ -29,82 +33,189 @@ func InitDocker() (*client.Client, context.Context) {
 	return cli, ctx
 }
 
-// DumpMySqlDatabase dumps the database from a docker container that is running MySQL/MariaDB
-func DumpMySqlDatabase(cli *client.Client, ctx context.Context, config BackupConfigDatabase, dumpToFile string) (err error) {
-	// Get all information about the container
+// InspectDatabaseContainer fetches all information about the container of the given database & checks if it's running.
+func InspectDatabaseContainer(cli *client.Client, ctx context.Context, config BackupConfigDatabase) (types.ContainerJSON, error) {
 	containerId := config.Container
 	container, err := cli.ContainerInspect(ctx, containerId)
 	if err != nil {
-		return err
+		return container, err
 	}
 
 	// Check if the container is running
 	if !container.State.Running {
-		return &DockerError{"Container " + containerId + " (db: " + config.Name + ") isn't running!"}
+		return container, &DockerError{"Container " + containerId + " (db: " + config.Name + ") isn't running!"}
 	}
 
-	// Determine the required mysqldump variables
-	env := parseEnvVariables(container.Config.Env)
-	database, err := getEnvOrDefault(env, "", config.Database)
-	if err != nil {
-		return err
+	return container, nil
+}
+
+// ResolveContainerVariable resolves the value of the variable, using the env of the given container if needed.
+func ResolveContainerVariable(container types.ContainerJSON, defaultValue string, variable BackupVariable) (string, error) {
+	return getEnvOrDefault(parseEnvVariables(container.Config.Env), defaultValue, variable)
+}
+
+// resolveWithEnvFallback resolves the variable if it's set. Otherwise, the given env variable of the container is used
+// when available, falling back to the default value.
+func resolveWithEnvFallback(container types.ContainerJSON, variable BackupVariable, envName string, defaultValue string) (string, error) {
+	if variable.VarType == "" || variable.Value == "" {
+		if envValue, ok := parseEnvVariables(container.Config.Env)[envName]; ok {
+			return envValue, nil
+		}
 	}
-	user, err := getEnvOrDefault(env, "root", config.User)
-	if err != nil {
-		return err
+
+	return ResolveContainerVariable(container, defaultValue, variable)
+}
+
+// lineLogger logs every line that is written to it, used for the stderr output of commands.
+type lineLogger struct {
+	logger   *log.Logger
+	buffer   []byte
+	lastLine string
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buffer = append(l.buffer, p...)
+	for {
+		index := bytes.IndexByte(l.buffer, '\n')
+		if index < 0 {
+			break
+		}
+
+		l.log(string(l.buffer[:index]))
+		l.buffer = l.buffer[index+1:]
 	}
-	password, err := getEnvOrDefault(env, "", config.Password)
-	if err != nil {
-		return err
+
+	return len(p), nil
+}
+
+func (l *lineLogger) log(line string) {
+	if line = strings.TrimSpace(line); line != "" {
+		l.logger.Println(line)
+		l.lastLine = line
+	}
+}
+
+// Flush logs any remaining output that didn't end with a new line.
+func (l *lineLogger) Flush() {
+	l.log(string(l.buffer))
+	l.buffer = nil
+}
+
+// execExitTimeout is how long an exec instance gets to register its exit code once its output has ended.
+const execExitTimeout = 30 * time.Second
+
+// runInContainer executes the command in the container through the Docker Engine API.
+// The stdin reader (optional) is fed to the command, its stdout is written to the given writer (optional)
+// & stderr is logged to the given logger. Fails if the command doesn't exit with code 0.
+// The env variables are set on the exec instance, so that their values don't show up in the process list.
+func runInContainer(
+	cli *client.Client,
+	ctx context.Context,
+	logger *log.Logger,
+	containerId string,
+	env map[string]string,
+	command []string,
+	stdin io.Reader,
+	stdout io.Writer,
+) error {
+	if stdout == nil {
+		stdout = io.Discard
 	}
 
-	// Create the file to write to
-	outfile, err := os.Create(dumpToFile)
+	// Build the env in a stable order
+	var processEnv []string
+	for name, value := range env {
+		processEnv = append(processEnv, name+"="+value)
+	}
+	sort.Strings(processEnv)
+
+	// Create the exec instance & attach to its output
+	execution, err := cli.ContainerExecCreate(ctx, containerId, types.ExecConfig{
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          processEnv,
+		Cmd:          command,
+	})
 	if err != nil {
 		return err
 	}
-	defer outfile.Close()
-
-	// Attempt to dump the database
-	dump := exec.Command(
-		"docker",
-		"exec",
-		containerId,
-		"mysqldump",
-		"-u",
-		user,
-		"-p"+password+"",
-		database,
-	)
-
-	// Output should be to the file
-	dump.Stdout = outfile
-
-	// Capture any error output
-	stderr, err := dump.StderrPipe()
+	attached, err := cli.ContainerExecAttach(ctx, execution.ID, types.ExecStartCheck{})
 	if err != nil {
 		return err
 	}
+	defer attached.Close()
+
+	// Stop reading the output once the context is cancelled
+	stopClosing := context.AfterFunc(ctx, attached.Close)
+	defer stopClosing()
+
+	// Feed the input to the command, closing its stdin once everything has been written
+	stdinDone := make(chan error, 1)
+	if stdin != nil {
+		go func() {
+			_, err := io.Copy(attached.Conn, stdin)
+			if closeErr := attached.CloseWrite(); err == nil {
+				err = closeErr
+			}
+			stdinDone <- err
+		}()
+	} else {
+		stdinDone <- nil
+	}
 
-	// Run the command
-	err = dump.Start()
+	// Split the multiplexed stream into stdout & stderr
+	stderr := &lineLogger{logger: logger}
+	_, err = stdcopy.StdCopy(stdout, stderr, attached.Reader)
+	stderr.Flush()
 	if err != nil {
-		_ = os.Remove(dumpToFile)
 		return err
 	}
-	defer func() {
-		if err = dump.Wait(); err != nil {
-			_ = os.Remove(dumpToFile)
+
+	// Check the exit code, the exec instance might need a moment to register that it has stopped
+	exitCtx, cancel := context.WithTimeout(ctx, execExitTimeout)
+	defer cancel()
+	timedOut := func() error {
+		return &DockerError{"'" + command[0] + "' in container " + containerId + " didn't exit after its output ended: " + exitCtx.Err().Error()}
+	}
+	for {
+		inspect, err := cli.ContainerExecInspect(exitCtx, execution.ID)
+		if err != nil {
+			if exitCtx.Err() != nil {
+				return timedOut()
+			}
+			return err
+		}
+
+		if inspect.Running {
+			select {
+			case <-exitCtx.Done():
+				return timedOut()
+			case <-time.After(100 * time.Millisecond):
+			}
+			continue
 		}
-	}()
 
-	// Read any possible error lines
-	errBuffer := bufio.NewScanner(stderr)
-	for errBuffer.Scan() {
-		log.Println(errBuffer.Text())
+		if inspect.ExitCode != 0 {
+			msg := "'" + command[0] + "' in container " + containerId + " exited with code " + strconv.Itoa(inspect.ExitCode)
+			if stderr.lastLine != "" {
+				msg += ": " + stderr.lastLine
+			}
+			return &DockerError{msg}
+		}
+
+		return <-stdinDone
+	}
+}
+
+// execInContainer executes the command in the container & returns its (trimmed) output.
+func execInContainer(cli *client.Client, ctx context.Context, logger *log.Logger, containerId string, env map[string]string, command []string) (string, error) {
+	var output bytes.Buffer
+	if err := runInContainer(cli, ctx, logger, containerId, env, command, nil, &output); err != nil {
+		return "", err
 	}
 
-	return err
+	return strings.TrimSpace(output.String()), nil
 }
 
 func parseEnvVariables(env []string) map[string]string {
@@ -0,0 +1,166 @@
+package unitski
+
+import (
+	"bytes"
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"io"
+	"os"
+	"strings"
+)
+
+const ageSuffix = ".age"
+const gpgSuffix = ".gpg"
+
+// BackupEncryption configures the public keys the backup files are encrypted to, either age or OpenPGP.
+// An empty block means the backups aren't encrypted.
+type BackupEncryption struct {
+	Age []string `json:"age"` // age X25519 recipients (age1...)
+	Gpg []string `json:"gpg"` // Paths to OpenPGP public keys (armored or binary)
+}
+
+// Enabled checks whether any recipient has been configured.
+func (e BackupEncryption) Enabled() bool {
+	return len(e.Age) > 0 || len(e.Gpg) > 0
+}
+
+// Extension returns the extension that is appended to encrypted backup files, empty if not encrypted.
+func (e BackupEncryption) Extension() string {
+	switch {
+	case len(e.Age) > 0:
+		return ageSuffix
+	case len(e.Gpg) > 0:
+		return gpgSuffix
+	default:
+		return ""
+	}
+}
+
+// Validate checks that only one kind of encryption is used & that all recipients can be loaded.
+func (e BackupEncryption) Validate() error {
+	if len(e.Age) > 0 && len(e.Gpg) > 0 {
+		return &FileError{"Encryption can either use age or gpg recipients, not both"}
+	}
+
+	_, err := e.NewWriter(io.Discard)
+	return err
+}
+
+// NewWriter wraps the given writer in an encryptor. Closing the encryptor doesn't close the given writer.
+func (e BackupEncryption) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	switch {
+	case len(e.Age) > 0:
+		var recipients []age.Recipient
+		for _, value := range e.Age {
+			recipient, err := age.ParseX25519Recipient(value)
+			if err != nil {
+				return nil, &FileError{"Invalid age recipient '" + value + "': " + err.Error()}
+			}
+			recipients = append(recipients, recipient)
+		}
+		return age.Encrypt(w, recipients...)
+
+	case len(e.Gpg) > 0:
+		var recipients openpgp.EntityList
+		for _, path := range e.Gpg {
+			keys, err := readKeyRing(path)
+			if err != nil {
+				return nil, &FileError{"Invalid gpg public key " + path + ": " + err.Error()}
+			}
+			recipients = append(recipients, keys...)
+		}
+		return openpgp.Encrypt(w, recipients, nil, &openpgp.FileHints{IsBinary: true}, nil)
+
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+// IsEncrypted checks whether the backup file is encrypted, based on its extension.
+func IsEncrypted(filename string) bool {
+	return strings.HasSuffix(filename, ageSuffix) || strings.HasSuffix(filename, gpgSuffix)
+}
+
+// withoutEncryptionSuffix strips the encryption extension from the filename, leaving the compression extension.
+func withoutEncryptionSuffix(filename string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(filename, ageSuffix), gpgSuffix)
+}
+
+// Identity holds the private keys used to decrypt backup files.
+type Identity struct {
+	age []age.Identity
+	gpg openpgp.EntityList
+}
+
+// LoadIdentity reads the private key(s) from an age identity file or an (unprotected) OpenPGP private key.
+func LoadIdentity(path string) (*Identity, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if identities, err := age.ParseIdentities(bytes.NewReader(content)); err == nil {
+		return &Identity{age: identities}, nil
+	}
+
+	keys, err := readKeyRing(path)
+	if err != nil {
+		return nil, &FileError{"Identity " + path + " is neither an age identity file nor an OpenPGP private key"}
+	}
+	for _, key := range keys {
+		if key.PrivateKey == nil {
+			return nil, &FileError{"Identity " + path + " contains a public key, a private key is required"}
+		}
+		if key.PrivateKey.Encrypted {
+			return nil, &FileError{"Identity " + path + " is protected with a passphrase, which isn't supported"}
+		}
+		for _, subkey := range key.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				return nil, &FileError{"Identity " + path + " is protected with a passphrase, which isn't supported"}
+			}
+		}
+	}
+
+	return &Identity{gpg: keys}, nil
+}
+
+// NewReader wraps the content of the backup file in a decryptor, if the file is encrypted.
+func (i *Identity) NewReader(filename string, r io.Reader) (io.Reader, error) {
+	if !IsEncrypted(filename) {
+		return r, nil
+	}
+	if i == nil {
+		return nil, &FileError{"Backup is encrypted, an identity (private key) is required: " + filename}
+	}
+
+	if strings.HasSuffix(filename, ageSuffix) {
+		if len(i.age) == 0 {
+			return nil, &FileError{"Backup is encrypted with age, the identity isn't an age identity: " + filename}
+		}
+		return age.Decrypt(r, i.age...)
+	}
+
+	if len(i.gpg) == 0 {
+		return nil, &FileError{"Backup is encrypted with gpg, the identity isn't an OpenPGP private key: " + filename}
+	}
+	message, err := openpgp.ReadMessage(r, i.gpg, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return message.UnverifiedBody, nil
+}
+
+// readKeyRing reads the OpenPGP keys from the file, which may either be armored or binary.
+func readKeyRing(path string) (openpgp.EntityList, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(content)); err == nil {
+		return keys, nil
+	}
+
+	return openpgp.ReadKeyRing(bytes.NewReader(content))
+}
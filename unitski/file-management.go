@@ -5,14 +5,45 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"sort"
+	"strings"
 	"time"
 )
 
+const yearlyDir = "yearly/"
 const monthlyDir = "monthly/"
 const weeklyDir = "weekly/"
 const dailyDir = "daily/"
-const fileDatePattern = "_(\\d{4}-\\d{2}-\\d{2})\\."
+const hourlyDir = "hourly/"
+
+// fileDatePattern matches the date (& time, if the target has hourly backups) in the name of a backup file.
+const fileDatePattern = "_(\\d{4}-\\d{2}-\\d{2})(?:_(\\d{2})-(\\d{2}))?\\."
+const fileDateFormat = "2006-01-02"
+const fileTimestampFormat = "2006-01-02_15-04"
+
+// BackupTimestamp returns the date (& time, if there are hourly backups) that is used in the name of a backup file.
+func BackupTimestamp(t time.Time, interval BackupInterval) string {
+	if interval.Hourly > 0 {
+		return t.Format(fileTimestampFormat)
+	}
+
+	return t.Format(fileDateFormat)
+}
+
+// backupTime parses the date (& time) from the name of the backup file.
+func backupTime(filename string) (time.Time, bool) {
+	match := regexp.MustCompile(fileDatePattern).FindStringSubmatch(filename)
+	if match == nil {
+		return time.Time{}, false
+	}
+
+	timestamp, format := match[1], fileDateFormat
+	if match[2] != "" {
+		timestamp, format = match[1]+"_"+match[2]+"-"+match[3], fileTimestampFormat
+	}
+	result, err := time.ParseInLocation(format, timestamp, time.Local)
+
+	return result, err == nil
+}
 
 type FileError struct {
 	msg string
@@ -23,8 +54,9 @@ func (error *FileError) Error() string {
 }
 
 type FolderCreator struct {
-	root string
-	err  error
+	root   string
+	logger *log.Logger
+	err    error
 }
 
 func (fc *FolderCreator) checkOrCreate(subFolder string, name string) {
@@ -37,7 +69,7 @@ func (fc *FolderCreator) checkOrCreate(subFolder string, name string) {
 
 	// Create the project folder if not done yet
 	if stat, dirErr := os.Stat(folder); os.IsNotExist(dirErr) {
-		log.Println("Creating " + name + ": " + folder)
+		fc.logger.Println("Creating " + name + ": " + folder)
 		if mkDirErr := os.Mkdir(folder, os.ModePerm); mkDirErr != nil {
 			fc.err = &FileError{"Failed to create " + name + ": " + folder + " | " + mkDirErr.Error()}
 		}
@@ -46,89 +78,99 @@ func (fc *FolderCreator) checkOrCreate(subFolder string, name string) {
 	}
 }
 
-func (fc *FolderCreator) checkIfExists(subFolder string, file string) (bool, error) {
-	file = fc.root + subFolder + file
-	if _, err := os.Stat(file); err == nil {
-		return true, nil
-	} else if os.IsNotExist(err) {
-		return false, nil
-	} else {
+// hasBackupFor checks whether the tier already has a backup of the same period (the same hour for hourly backups,
+// the same day for the other tiers) as the given backup time.
+func (fc *FolderCreator) hasBackupFor(subFolder string, backupAt time.Time) (bool, error) {
+	previousBackups, err := getPreviousBackups(fc.root + subFolder)
+	if err != nil {
 		return false, err
 	}
+
+	period := fileDateFormat
+	if subFolder == hourlyDir {
+		period = "2006-01-02_15"
+	}
+	for _, previous := range previousBackups {
+		if previousAt, ok := backupTime(previous); ok && previousAt.Format(period) == backupAt.Format(period) {
+			return true, nil
+		}
+	}
+
+	return false, nil
 }
 
 func (fc *FolderCreator) checkShouldBackup(
 	subFolder string,
-	file string,
-	interval int,
-	shouldBackupToday func() bool,
+	backupAt time.Time,
+	interval BackupInterval,
 ) (backup bool) {
 	// Don't backup when there's no interval
-	if fc.err != nil || interval <= 0 {
+	if fc.err != nil || interval.Keep(subFolder) <= 0 {
 		return false
 	}
 
-	var err error
-
-	// Check if the file already exists
-	if exists, err := fc.checkIfExists(subFolder, file); err == nil && !exists {
-		// Doesn't exist yet, check if we should make a back-up (today)
-		if backup = shouldBackupToday(); !backup {
-			// We shouldn't back-up today... but we might still want to if there are no back-ups yet?
-			if previousBackups, err := getPreviousBackups(fc.root + subFolder); err == nil {
-				backup = len(previousBackups) == 0
-			}
-		}
-	} else if exists {
-		log.Println("File " + subFolder + file + " already exists")
+	schedule, err := interval.GetSchedule(subFolder)
+	if err != nil {
+		fc.err = err
+		return false
 	}
 
-	if err != nil {
+	// Check if there's already a backup of this period
+	if exists, err := fc.hasBackupFor(subFolder, backupAt); err != nil {
 		fc.err = err
+	} else if exists {
+		fc.logger.Println("Backup of " + BackupTimestamp(backupAt, interval) + " already exists in " + subFolder)
+	} else if backup = schedule.Matches(backupAt); !backup {
+		// We shouldn't back-up now... but we might still want to if there are no back-ups yet?
+		if previousBackups, err := getPreviousBackups(fc.root + subFolder); err == nil {
+			backup = len(previousBackups) == 0
+		}
 	}
 
 	return backup
 }
 
+// ShouldBackup holds the tiers (folders) a new backup should be added to.
 type ShouldBackup struct {
-	daily   bool
-	weekly  bool
-	monthly bool
+	tiers map[string]bool
 }
 
 func (sb *ShouldBackup) Any() bool {
-	return sb.daily || sb.weekly || sb.monthly
+	for _, should := range sb.tiers {
+		if should {
+			return true
+		}
+	}
+
+	return false
 }
 
 // CheckProjectFolder checks whether the project folder is correctly backed-up & whether a backup should take place.
-func CheckProjectFolder(projectFolder string, filename string, interval BackupInterval) (shouldBackup ShouldBackup, err error) {
-	shouldBackup = ShouldBackup{}
+// The schedules of the tiers are matched against the time of the backup run (including the hour).
+func CheckProjectFolder(projectFolder string, backupAt time.Time, interval BackupInterval, logger *log.Logger) (shouldBackup ShouldBackup, err error) {
+	shouldBackup = ShouldBackup{tiers: map[string]bool{}}
 
-	// Create the project folder structure if not done yet
-	creator := FolderCreator{root: projectFolder}
+	// Create the project folder structure if not done yet, the optional tiers only if they're used
+	creator := FolderCreator{root: projectFolder, logger: logger}
 	creator.checkOrCreate("", "root backup folder")
-	creator.checkOrCreate(monthlyDir, "monthly backup folder")
-	creator.checkOrCreate(weeklyDir, "weekly backup folder")
-	creator.checkOrCreate(dailyDir, "daily backup folder")
+	for _, tier := range backupTiers {
+		if (tier != yearlyDir && tier != hourlyDir) || interval.Keep(tier) > 0 {
+			creator.checkOrCreate(tier, strings.TrimSuffix(tier, "/")+" backup folder")
+		}
+	}
 	if creator.err != nil {
 		return shouldBackup, creator.err
 	}
 
 	// Check that at least one interval is active
-	if interval.Daily <= 0 && interval.Weekly <= 0 && interval.Monthly <= 0 {
+	if !interval.Any() {
 		return shouldBackup, &FileError{"All intervals have 0, this backup will never run!"}
 	}
 
-	// Check whether backups should be made
-	shouldBackup.daily = creator.checkShouldBackup(dailyDir, filename, interval.Daily, func() bool {
-		return true // Every day
-	})
-	shouldBackup.weekly = creator.checkShouldBackup(weeklyDir, filename, interval.Weekly, func() bool {
-		return time.Now().Weekday() == time.Monday // Every monday
-	})
-	shouldBackup.monthly = creator.checkShouldBackup(monthlyDir, filename, interval.Monthly, func() bool {
-		return time.Now().Day() == 1 // First of the month
-	})
+	// Check whether backups should be made according to the schedules of the tiers
+	for _, tier := range backupTiers {
+		shouldBackup.tiers[tier] = creator.checkShouldBackup(tier, backupAt, interval)
+	}
 
 	return shouldBackup, creator.err
 }
@@ -152,180 +194,24 @@ func getPreviousBackups(folder string) (hasBackup []string, err error) {
 	return result, nil
 }
 
-type BackupFolder struct {
-	folder            string
-	referencingFolder *BackupFolder // The child (faster change rate) that might reference this folder
-}
-
-// getChildSymlinkFor resolves whether this file is used by this folder or any of its possible referencing folders.
-// Returns an absolute path to the symlink
-// Might return an error of the file isn't a symlink
-func (f *BackupFolder) getSymlinkFor(file string) (symlinkPath string, err error) {
-	symlinkPath = f.folder + file
-
-	// Check if the file exists in this folder
-	if stat, err := os.Lstat(symlinkPath); err == nil {
-		// We have the file, it should be a symlink
-		if stat.Mode()&os.ModeSymlink == os.ModeSymlink {
-			return symlinkPath, err
-		} else {
-			return "", &FileError{"Child folder has a file which should be a symlink, ready for rotate, but isn't: " + symlinkPath}
-		}
-	} else if !os.IsNotExist(err) {
-		// Great if it doesn't exist, if there' something else wrong we got a problem.
-		return "", err
-	}
-
-	// Check a possible referencing folder
-	if f.referencingFolder != nil {
-		return f.referencingFolder.getSymlinkFor(file)
-	}
-
-	// Nothing found
-	return "", err
-}
-
-type FileRotator struct {
-	rootFolder          string
-	filename            string
-	originalFilePath    string
-	lowestLevelLocation string
-	err                 error
-}
-
-func (r *FileRotator) backupTo(subFolder string, should bool) {
-	// Check if we even should back up for this folder
-	if r.err != nil || !should {
-		return
-	}
-
-	toPath := r.rootFolder + subFolder + r.filename
-
-	// We should back up to the given folder, check if we're the first to back up the file
-	if r.lowestLevelLocation == "" {
-		// We're the first, move the file to the folder
-		if err := os.Rename(r.originalFilePath, toPath); err != nil {
-			r.err = err
-			return
-		}
-	} else {
-		// The file is already moved, symlink to it
-		// Relative path = 'Current Subfolder' => 'Go up, back to to rootFolder
-		relativePath := "./../" + r.lowestLevelLocation + r.filename
-		if err := os.Symlink(relativePath, toPath); err != nil {
-			r.err = err
-			return
-		}
-	}
-
-	// Update the lowestLevelLocation to us so that lower levels go through our symlink
-	// We need to do this as files might rotate from our parent to us, all lower level symlinks keep working
-	r.lowestLevelLocation = subFolder
-}
-
-func (r *FileRotator) purge(backupType BackupFolder, keep int) {
-	if r.err != nil || keep == 0 {
-		return
-	}
-
-	// Retrieve all (previous) backups from the folder
-	backups, err := getPreviousBackups(backupType.folder)
-	if err != nil {
-		r.err = err
-		return
-	}
-
-	// Check if there are too many
-	totalBackupsToBeDeleted := len(backups) - keep
-	if totalBackupsToBeDeleted <= 0 {
-		// Nothing to rotate
-		return
-	}
-
-	// Sort them by oldest -> newest
-	regex := regexp.MustCompile(fileDatePattern)
-	toUnix := func(file string) int64 {
-		date := regex.FindStringSubmatch(file)[0]
-		if result, err := time.Parse("2006-01-02", date); err != nil {
-			return result.Unix()
-		} else {
-			// Shouldn't happen as it got matched by previous function
-			panic(err)
-		}
-	}
-	sort.SliceStable(backups, func(i, j int) bool {
-		return toUnix(backups[i]) < toUnix(backups[j])
-	})
-
-	// Slice the items that we need to delete
-	toDelete := backups[:totalBackupsToBeDeleted]
-	for _, deleteFile := range toDelete {
-		deleteFileAbsPath := backupType.folder + deleteFile
-
-		// Check if we should move the file to a referencing folder
-		if backupType.referencingFolder != nil {
-			if deletedFileDestination, err := backupType.referencingFolder.getSymlinkFor(deleteFile); err != nil {
-				// Error occurred while resolving the symlink
-				r.err = err
-				return
-			} else if deletedFileDestination != "" {
-				// Found a symlink, remove that symlink
-				if err := os.Remove(deletedFileDestination); err != nil {
-					r.err = err
-					return
-				}
-
-				// Move our file to the destination of the symlink
-				// Note that our file might also be a symlink, this should be fine as it's a relative symlink with the same level of depth
-				if err := os.Rename(deleteFileAbsPath, deletedFileDestination); err != nil {
-					r.err = err
-					return
-				}
-
-				// All good!
-				log.Println("Moved " + deleteFileAbsPath + " to " + deletedFileDestination)
-				continue
-			}
-		}
-
-		// Nothing references this file. Just remove it.
-		if err := os.Remove(deleteFileAbsPath); err != nil {
-			r.err = err
-			return
-		}
-	}
-}
-
 // RotateFile will rotate the given file into the backup folder
-// This also removes any old files that are due for deletion
-func RotateFile(createdFilePath string, shouldBackup ShouldBackup, interval BackupInterval) error {
-	// Explode the filepath & store in a FileRotator
-	rotator := FileRotator{
-		rootFolder:       filepath.Dir(createdFilePath) + "/",
-		filename:         filepath.Base(createdFilePath),
-		originalFilePath: createdFilePath,
+// This also removes any old files that are due for deletion, according to the interval & retention
+func RotateFile(createdFilePath string, shouldBackup ShouldBackup, interval BackupInterval, retention BackupRetention, logger *log.Logger) error {
+	projectFolder := filepath.Dir(createdFilePath) + "/"
+	stat, err := os.Stat(createdFilePath)
+	if err != nil {
+		return err
 	}
 
-	// Move the file to the 'oldest' folder
-	rotator.backupTo(monthlyDir, shouldBackup.monthly)
-	rotator.backupTo(weeklyDir, shouldBackup.weekly)
-	rotator.backupTo(dailyDir, shouldBackup.daily)
-	if rotator.err != nil {
-		return rotator.err
+	state, err := ReadProjectState(projectFolder)
+	if err != nil {
+		return err
 	}
 
-	// Create structure of folders
-	dailyFolder := BackupFolder{folder: rotator.rootFolder + dailyDir}
-	weeklyFolder := BackupFolder{rotator.rootFolder + weeklyDir, &dailyFolder}
-	monthlyFolder := BackupFolder{rotator.rootFolder + monthlyDir, &weeklyFolder}
-
-	// Rotate out any old files
-	rotator.purge(dailyFolder, interval.Daily)
-	rotator.purge(weeklyFolder, interval.Weekly)
-	rotator.purge(monthlyFolder, interval.Monthly)
-	if rotator.err != nil {
-		return rotator.err
+	actions, err := PlanRotation(state, filepath.Base(createdFilePath), stat.Size(), shouldBackup, interval, retention, time.Now())
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return ApplyRotation(projectFolder, actions, logger)
 }
@@ -0,0 +1,34 @@
+package unitski
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockFile is the name of the lock file in the backup folder, locked while backups are made or pruned.
+const lockFile = ".unitski.lock"
+
+// LockBackupFolder makes sure only one backup run or prune changes the backup folder at a time.
+// Fails right away if the folder is locked, returns a function that releases the lock otherwise.
+func LockBackupFolder(folder string) (unlock func(), err error) {
+	file, err := os.OpenFile(filepath.Join(folder, lockFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	// The lock is released by the OS if the process dies, so a crash never leaves it behind
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, &FileError{"The backup folder is in use by another backup or prune: " + folder}
+		}
+		return nil, err
+	}
+
+	return func() {
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		_ = file.Close()
+	}, nil
+}
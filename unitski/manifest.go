@@ -0,0 +1,138 @@
+package unitski
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// manifestFile is the name of the manifest in the root of each project folder.
+const manifestFile = "manifest.json"
+
+// Manifest records the checksum & size of every backup in a project folder, keyed by the filename of the backup.
+// The filename is the same in every tier, so moving or symlinking a backup to a different tier doesn't change it.
+type Manifest struct {
+	Files map[string]ManifestEntry `json:"files"`
+}
+
+type ManifestEntry struct {
+	Sha256  string    `json:"sha256"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// LoadManifest reads the manifest of the project folder, which is empty if it doesn't exist (yet).
+func LoadManifest(projectFolder string) (Manifest, error) {
+	manifest := Manifest{Files: map[string]ManifestEntry{}}
+
+	content, err := os.ReadFile(filepath.Join(projectFolder, manifestFile))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	} else if err != nil {
+		return manifest, err
+	}
+
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return manifest, &FileError{"Invalid manifest in " + projectFolder + ": " + err.Error()}
+	}
+	if manifest.Files == nil {
+		manifest.Files = map[string]ManifestEntry{}
+	}
+
+	return manifest, nil
+}
+
+// Save writes the manifest to the project folder, replacing the previous one at once.
+func (m Manifest) Save(projectFolder string) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(projectFolder, manifestFile)
+	if err := os.WriteFile(path+partialSuffix, append(content, '\n'), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+partialSuffix, path)
+}
+
+// Check compares the file with the entry, returning an error if it has been truncated or altered.
+func (e ManifestEntry) Check(path string) error {
+	actual, err := ChecksumFile(path)
+	if err != nil {
+		return err
+	}
+
+	if actual.Size != e.Size {
+		return &FileError{"Size doesn't match the manifest, expected " + FormatSize(e.Size) + " but is " + FormatSize(actual.Size) + ": " + path}
+	}
+	if actual.Sha256 != e.Sha256 {
+		return &FileError{"SHA-256 doesn't match the manifest: " + path}
+	}
+
+	return nil
+}
+
+// ChecksumFile calculates the manifest entry of a file.
+func ChecksumFile(path string) (ManifestEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+
+	return ManifestEntry{hex.EncodeToString(hash.Sum(nil)), size, time.Now()}, nil
+}
+
+// recordInManifest adds the backup to the manifest of the folder it's in.
+func recordInManifest(path string, entry ManifestEntry) error {
+	projectFolder := filepath.Dir(path)
+	manifest, err := LoadManifest(projectFolder)
+	if err != nil {
+		return err
+	}
+
+	manifest.Files[filepath.Base(path)] = entry
+	return manifest.Save(projectFolder)
+}
+
+// pruneManifest removes all backups that no longer exist in any of the tiers from the manifest of the project folder.
+func pruneManifest(projectFolder string) error {
+	manifest, err := LoadManifest(projectFolder)
+	if err != nil {
+		return err
+	}
+
+	backups, err := FindBackups(projectFolder)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, backup := range backups {
+		existing[backup.Filename] = backup.Path != ""
+	}
+
+	changed := false
+	for filename := range manifest.Files {
+		if !existing[filename] {
+			delete(manifest.Files, filename)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	return manifest.Save(projectFolder)
+}
@@ -0,0 +1,358 @@
+package unitski
+
+import (
+	"archive/tar"
+	"context"
+	"github.com/docker/docker/client"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RestoreDatabase restores the backup file into the container of the given database config.
+// The backup is decompressed on the fly & piped into the database client in the container.
+// If a target database is given, the backup is restored into that database instead of the configured one.
+// The identity is only required if the backup is encrypted.
+func RestoreDatabase(
+	cli *client.Client,
+	ctx context.Context,
+	config BackupConfigDatabase,
+	backupFile string,
+	targetDatabase string,
+	identity *Identity,
+	logger *log.Logger,
+) error {
+	// Get all information about the container
+	container, err := InspectDatabaseContainer(cli, ctx, config)
+	if err != nil {
+		return err
+	}
+
+	// Open the backup
+	backup, err := OpenArtifact(backupFile, identity)
+	if err != nil {
+		return err
+	}
+	defer backup.Close()
+
+	switch config.Type {
+	case "", DbTypeMySql:
+		credentials, err := mySqlCredentials(container, config)
+		if err != nil {
+			return err
+		}
+		if targetDatabase != "" {
+			credentials.database = targetDatabase
+		}
+		if credentials.database == "" {
+			return &DockerError{"Unable to restore " + config.Name + ", no database to restore into"}
+		}
+
+		// Make sure the database exists
+		createQuery := "CREATE DATABASE IF NOT EXISTS `" + strings.ReplaceAll(credentials.database, "`", "``") + "`"
+		if _, err := execInContainer(cli, ctx, logger, config.Container, credentials.mySqlEnv(), []string{
+			"mysql", "-u", credentials.user, "-e", createQuery,
+		}); err != nil {
+			return err
+		}
+
+		return runInContainer(cli, ctx, logger, config.Container, credentials.mySqlEnv(), []string{
+			"mysql", "-u", credentials.user, credentials.database,
+		}, backup, nil)
+
+	case DbTypePostgres:
+		credentials, err := postgresCredentials(container, config)
+		if err != nil {
+			return err
+		}
+
+		// A dump of all databases (pg_dumpall) is restored through the default database.
+		// It creates databases, which can't be done inside a transaction, so it stops at the first error instead.
+		if credentials.database == "" {
+			if targetDatabase != "" {
+				return &DockerError{"Unable to restore a dump of all databases into a single database: " + targetDatabase}
+			}
+			return runInContainer(cli, ctx, logger, config.Container, credentials.postgresEnv(), []string{
+				"psql", "-U", credentials.user, "-d", "postgres", "-q", "-v", "ON_ERROR_STOP=1",
+			}, backup, nil)
+		}
+		if targetDatabase != "" {
+			credentials.database = targetDatabase
+		}
+
+		// Make sure the database exists
+		exists, err := execInContainer(cli, ctx, logger, config.Container, credentials.postgresEnv(), []string{
+			"psql", "-U", credentials.user, "-d", "postgres", "-t", "-A", "-c",
+			"SELECT 1 FROM pg_database WHERE datname = " + quoteSqlString(credentials.database),
+		})
+		if err != nil {
+			return err
+		}
+		if exists != "1" {
+			if _, err := execInContainer(cli, ctx, logger, config.Container, credentials.postgresEnv(), []string{
+				"createdb", "-U", credentials.user, credentials.database,
+			}); err != nil {
+				return err
+			}
+		}
+
+		// Restored in a single transaction, so a failed restore leaves the database as it was
+		return runInContainer(cli, ctx, logger, config.Container, credentials.postgresEnv(), []string{
+			"psql", "-U", credentials.user, "-d", credentials.database, "-q", "-v", "ON_ERROR_STOP=1", "--single-transaction",
+		}, backup, nil)
+
+	case DbTypeMongo:
+		database, env, command, err := mongoCommand(container, config, "mongorestore")
+		if err != nil {
+			return err
+		}
+
+		// Collections that are in the backup replace the existing ones
+		command += " --drop"
+		if targetDatabase != "" {
+			if database == "" {
+				return &DockerError{"Unable to restore a dump of all databases into a single database: " + targetDatabase}
+			}
+			env["UNITSKI_MONGO_NS_FROM"] = database + ".*"
+			env["UNITSKI_MONGO_NS_TO"] = targetDatabase + ".*"
+			command += " --nsFrom=\"$UNITSKI_MONGO_NS_FROM\" --nsTo=\"$UNITSKI_MONGO_NS_TO\""
+		}
+
+		return runInContainer(cli, ctx, logger, config.Container, env, []string{"sh", "-c", command}, backup, nil)
+
+	default:
+		return &DockerError{"Restoring " + string(config.Type) + " databases isn't supported, restore " + backupFile + " manually"}
+	}
+}
+
+// ExtractTarBall extracts the tar ball into the target folder, keeping modes, ownership (if allowed), links & times.
+// Only the entries that match one of the given paths or glob patterns (or are inside a matching folder) are extracted,
+// everything if no paths are given. Nothing is written on a dry-run, the entries that would be restored are still returned.
+// The identity is only required if the tar ball is encrypted.
+func ExtractTarBall(tarBallFile string, targetFolder string, paths []string, dryRun bool, identity *Identity) (restored []string, warnings []string, err error) {
+	// Archive paths are relative, but are matched as the absolute path they were backed up from
+	var patterns []string
+	for _, path := range paths {
+		if strings.Contains(path, "/") && !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		patterns = append(patterns, path)
+	}
+	selector, err := NewExcludeMatcher(patterns)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tarBall, err := OpenArtifact(tarBallFile, identity)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tarBall.Close()
+
+	extractor := TarExtractor{
+		reader:   tar.NewReader(tarBall),
+		target:   targetFolder,
+		dryRun:   dryRun,
+		folders:  map[string]time.Time{},
+		symlinks: map[string]bool{},
+	}
+	for {
+		header, err := extractor.reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return extractor.restored, extractor.warnings, &FileError{"Failed to read " + tarBallFile + ": " + err.Error()}
+		}
+
+		if len(paths) > 0 && !selectedEntry(selector, header.Name) {
+			continue
+		}
+		if err := extractor.extract(header); err != nil {
+			return extractor.restored, extractor.warnings, err
+		}
+	}
+
+	// Folder times are set last, extracting their content changes them
+	for folder, modTime := range extractor.folders {
+		_ = os.Chtimes(folder, modTime, modTime)
+	}
+
+	return extractor.restored, extractor.warnings, nil
+}
+
+// selectedEntry checks whether the archive entry or one of its parent folders is matched by the selector.
+func selectedEntry(selector *ExcludeMatcher, name string) bool {
+	for path := "/" + strings.Trim(name, "/"); path != "/"; path = filepath.Dir(path) {
+		if selector.Matches(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TarExtractor writes the entries of a tar archive into a target folder.
+type TarExtractor struct {
+	reader   *tar.Reader
+	target   string
+	dryRun   bool
+	folders  map[string]time.Time // Extracted folders => Their modification time
+	symlinks map[string]bool      // Symlinks created by the restore
+	restored []string
+	warnings []string
+}
+
+func (e *TarExtractor) warn(msg string) {
+	e.warnings = append(e.warnings, msg)
+}
+
+// extract writes a single entry of the archive to the target folder.
+// Only returns an error if the target can't be written to, unsupported or unsafe entries are skipped with a warning.
+func (e *TarExtractor) extract(header *tar.Header) error {
+	// Never write outside the target folder
+	name := filepath.Clean("/" + header.Name)
+	if name == "/" {
+		return nil
+	}
+	path := filepath.Join(e.target, name)
+
+	switch header.Typeflag {
+	case tar.TypeDir, tar.TypeReg, tar.TypeSymlink, tar.TypeLink, tar.TypeFifo:
+	default:
+		e.warn("Skipped " + name + ": unsupported type of entry")
+		return nil
+	}
+
+	// Nor through a symlink, which might be restored by an earlier entry or in the target folder already
+	if symlink := e.symlinkInPath(path, header.Typeflag == tar.TypeDir); symlink != "" {
+		e.warn("Skipped " + name + ": " + symlink + " is a symlink")
+		return nil
+	}
+	if header.Typeflag == tar.TypeLink {
+		linkTarget := filepath.Join(e.target, filepath.Clean("/"+header.Linkname))
+		if symlink := e.symlinkInPath(linkTarget, false); symlink != "" {
+			e.warn("Skipped hard link " + name + ": " + symlink + " is a symlink")
+			return nil
+		}
+	}
+
+	e.restored = append(e.restored, name)
+	if header.Typeflag == tar.TypeSymlink {
+		e.symlinks[path] = true
+	} else {
+		delete(e.symlinks, path)
+	}
+	if e.dryRun {
+		return nil
+	}
+
+	// => Make sure the parent exists & nothing is in the way
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return &FileError{"Failed to create folder " + filepath.Dir(path) + ": " + err.Error()}
+	}
+	if header.Typeflag != tar.TypeDir {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return &FileError{"Failed to replace " + path + ": " + err.Error()}
+		}
+	}
+
+	// => Create the entry
+	var err error
+	switch header.Typeflag {
+	case tar.TypeDir:
+		err = os.MkdirAll(path, 0700)
+		e.folders[path] = header.ModTime
+	case tar.TypeReg:
+		err = e.writeFile(path)
+	case tar.TypeSymlink:
+		err = os.Symlink(header.Linkname, path)
+	case tar.TypeLink:
+		linkTarget := filepath.Join(e.target, filepath.Clean("/"+header.Linkname))
+		if linkErr := os.Link(linkTarget, path); linkErr != nil {
+			e.warn("Skipped hard link " + name + ": " + linkErr.Error())
+		}
+		return nil
+	case tar.TypeFifo:
+		err = syscall.Mkfifo(path, uint32(header.Mode&07777))
+	}
+	if err != nil {
+		return &FileError{"Failed to restore " + path + ": " + err.Error()}
+	}
+
+	// => Restore the ownership (only allowed as root) & permissions
+	_ = os.Lchown(path, header.Uid, header.Gid)
+	if header.Typeflag != tar.TypeSymlink {
+		if err := os.Chmod(path, os.FileMode(header.Mode).Perm()|modeBits(header.Mode)); err != nil {
+			e.warn("Failed to set the permissions of " + path + ": " + err.Error())
+		}
+		_ = os.Chtimes(path, header.ModTime, header.ModTime)
+	}
+
+	return nil
+}
+
+// symlinkInPath returns the first folder between the target folder & the given path that is a symlink, including the
+// path itself if requested. Returns an empty string if none of them is a symlink.
+// When restoring to / only the symlinks created by the restore count, the system has symlinked folders of its own
+// (i.e. /var on macOS) which would otherwise skip everything.
+func (e *TarExtractor) symlinkInPath(path string, includeSelf bool) string {
+	relative, err := filepath.Rel(e.target, path)
+	if err != nil {
+		return ""
+	}
+
+	parts := strings.Split(relative, string(filepath.Separator))
+	if !includeSelf {
+		parts = parts[:len(parts)-1]
+	}
+
+	current := e.target
+	for _, part := range parts {
+		current = filepath.Join(current, part)
+		if e.symlinks[current] {
+			return current
+		}
+		if e.target != "/" {
+			if stat, err := os.Lstat(current); err == nil && stat.Mode()&os.ModeSymlink == os.ModeSymlink {
+				return current
+			}
+		}
+	}
+
+	return ""
+}
+
+// writeFile writes the content of the current entry to the given path.
+func (e *TarExtractor) writeFile(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, e.reader); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// modeBits converts the setuid, setgid & sticky bits of a tar header mode to their os.FileMode equivalent.
+func modeBits(mode int64) os.FileMode {
+	var bits os.FileMode
+	if mode&04000 != 0 {
+		bits |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		bits |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		bits |= os.ModeSticky
+	}
+
+	return bits
+}
@@ -0,0 +1,53 @@
+package unitski
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ageUnits = map[string]time.Duration{
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+	"y": 365 * 24 * time.Hour,
+}
+
+// BackupRetention configures additions to keeping the newest N backups per tier (see BackupInterval).
+type BackupRetention struct {
+	MaxAge  map[string]string `json:"max-age"`  // Per tier, also keep all backups younger than this (i.e. 14d), the newest N are always kept
+	MaxSize string            `json:"max-size"` // Max total size of the backups of the target, the oldest are removed first
+}
+
+// ParseAge parses an age with a unit, i.e. 36h, 14d, 8w or 1y.
+func ParseAge(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	for unit, duration := range ageUnits {
+		if number, found := strings.CutSuffix(value, unit); found {
+			if amount, err := strconv.Atoi(number); err == nil && amount > 0 {
+				return time.Duration(amount) * duration, nil
+			}
+		}
+	}
+
+	return 0, &FileError{"Invalid age '" + value + "', expected a number with h, d, w or y (i.e. 14d)"}
+}
+
+// GetMaxAge returns the max age of backups in the tier, 0 if the number of backups is used instead.
+func (r BackupRetention) GetMaxAge(tier string) (time.Duration, error) {
+	value := r.MaxAge[strings.TrimSuffix(tier, "/")]
+	if value == "" {
+		return 0, nil
+	}
+
+	return ParseAge(value)
+}
+
+// GetMaxSize returns the max total size of the backups, 0 if there's no max.
+func (r BackupRetention) GetMaxSize() (int64, error) {
+	if r.MaxSize == "" {
+		return 0, nil
+	}
+
+	return ParseSize(r.MaxSize)
+}
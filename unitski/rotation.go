@@ -1 +1,450 @@
 package unitski
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RotationActionType string
+
+const (
+	ActionMove    RotationActionType = "move"    // Move a new backup from the project folder into a tier
+	ActionSymlink RotationActionType = "symlink" // Symlink a backup in a tier to the same backup in a slower tier
+	ActionPromote RotationActionType = "promote" // Move a backup to a faster tier, replacing the symlink to it
+	ActionDelete  RotationActionType = "delete"  // Delete a backup (or a symlink to it) from a tier
+)
+
+// RotationAction is a single step of a rotation plan, the tiers are relative to the project folder.
+type RotationAction struct {
+	Type     RotationActionType
+	Filename string
+	From     string // Tier the backup is in, empty for a new backup in the project folder
+	To       string // Tier the backup is moved or linked to
+	LinkTo   string // Tier a deleted symlink points to, empty if the file itself is deleted
+	Reason   string
+}
+
+func (a RotationAction) String() string {
+	var description string
+	switch a.Type {
+	case ActionMove:
+		description = "move " + a.Filename + " into " + a.To
+	case ActionSymlink:
+		description = "symlink " + a.To + a.Filename + " -> " + a.From + a.Filename
+	case ActionPromote:
+		description = "move " + a.From + a.Filename + " down to " + a.To + a.Filename + " (replacing the symlink)"
+	case ActionDelete:
+		description = "delete " + a.From + a.Filename
+		if a.LinkTo != "" {
+			description += " (symlink to " + a.LinkTo + ")"
+		}
+	}
+
+	if a.Reason != "" {
+		description += ": " + a.Reason
+	}
+	return description
+}
+
+// TierEntry is a backup in a tier folder, either the physical file or a symlink to the same backup in a slower tier.
+type TierEntry struct {
+	Filename string
+	LinkTo   string // Tier the symlink points to, empty for the physical file
+	Size     int64  // Size of the physical file
+}
+
+// ProjectState holds the backups in each tier folder of a project: Tier => Filename => Entry.
+type ProjectState map[string]map[string]TierEntry
+
+// ReadProjectState reads the backups in the tier folders of the project, missing tiers are empty.
+func ReadProjectState(projectFolder string) (ProjectState, error) {
+	regex := regexp.MustCompile(fileDatePattern)
+	state := ProjectState{}
+
+	for _, tier := range backupTiers {
+		state[tier] = map[string]TierEntry{}
+
+		entries, err := os.ReadDir(projectFolder + tier)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return state, err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || !regex.MatchString(entry.Name()) {
+				continue
+			}
+
+			tierEntry := TierEntry{Filename: entry.Name()}
+			if entry.Type()&os.ModeSymlink != 0 {
+				link, err := os.Readlink(projectFolder + tier + entry.Name())
+				if err != nil {
+					return state, err
+				}
+				tierEntry.LinkTo = filepath.Base(filepath.Dir(link)) + "/"
+			} else if info, err := entry.Info(); err == nil {
+				tierEntry.Size = info.Size()
+			}
+			state[tier][entry.Name()] = tierEntry
+		}
+	}
+
+	return state, nil
+}
+
+// backups returns the filenames of all backups in the project, sorted from oldest to newest.
+func (s ProjectState) backups() []string {
+	var filenames []string
+	seen := map[string]bool{}
+	for _, tier := range backupTiers {
+		for filename := range s[tier] {
+			if !seen[filename] {
+				seen[filename] = true
+				filenames = append(filenames, filename)
+			}
+		}
+	}
+	sortBackups(filenames)
+
+	return filenames
+}
+
+// size returns the size of the physical file of the backup.
+func (s ProjectState) size(filename string) int64 {
+	for _, tier := range backupTiers {
+		if entry, exists := s[tier][filename]; exists && entry.LinkTo == "" {
+			return entry.Size
+		}
+	}
+
+	return 0
+}
+
+// totalSize returns the size of all physical backup files in the project.
+func (s ProjectState) totalSize() (total int64) {
+	for _, filename := range s.backups() {
+		total += s.size(filename)
+	}
+
+	return total
+}
+
+// sortBackups sorts the filenames of backups from oldest to newest.
+func sortBackups(filenames []string) {
+	sort.SliceStable(filenames, func(i, j int) bool {
+		// Always matches, the files got matched by the same pattern
+		first, _ := backupTime(filenames[i])
+		second, _ := backupTime(filenames[j])
+		if first.Equal(second) {
+			return filenames[i] < filenames[j]
+		}
+		return first.Before(second)
+	})
+}
+
+// RotationPlanner decides which actions are needed to rotate a project, applying them to its (in memory) state.
+type RotationPlanner struct {
+	state   ProjectState
+	actions []RotationAction
+	err     error
+}
+
+// NewRotationPlanner starts a plan for the project in the given state, the state is modified while planning.
+func NewRotationPlanner(state ProjectState) *RotationPlanner {
+	for _, tier := range backupTiers {
+		if state[tier] == nil {
+			state[tier] = map[string]TierEntry{}
+		}
+	}
+
+	return &RotationPlanner{state: state}
+}
+
+// Actions returns the planned actions, or the error if planning failed.
+func (p *RotationPlanner) Actions() ([]RotationAction, error) {
+	return p.actions, p.err
+}
+
+func (p *RotationPlanner) plan(action RotationAction) {
+	p.actions = append(p.actions, action)
+}
+
+// AddBackup adds a new backup (of the given size) in the project folder to the tiers it should be backed up to.
+// The file is moved to the slowest tier, the faster tiers symlink to the tier before them.
+func (p *RotationPlanner) AddBackup(filename string, size int64, shouldBackup ShouldBackup) {
+	if p.err != nil {
+		return
+	}
+
+	lowestLevelLocation := ""
+	for _, tier := range backupTiers {
+		if !shouldBackup.tiers[tier] {
+			continue
+		}
+
+		if lowestLevelLocation == "" {
+			p.plan(RotationAction{Type: ActionMove, Filename: filename, To: tier})
+			p.state[tier][filename] = TierEntry{Filename: filename, Size: size}
+		} else {
+			p.plan(RotationAction{Type: ActionSymlink, Filename: filename, From: lowestLevelLocation, To: tier})
+			p.state[tier][filename] = TierEntry{Filename: filename, LinkTo: lowestLevelLocation}
+		}
+
+		// Lower levels go through our symlink, files might rotate from our parent to us
+		lowestLevelLocation = tier
+	}
+}
+
+// Purge removes the oldest backups from each tier, keeping the newest N (interval) & with a max age also those younger.
+// Backups that are still symlinked from a faster tier are promoted to that tier instead, starting at the fastest tier.
+func (p *RotationPlanner) Purge(interval BackupInterval, retention BackupRetention, now time.Time) {
+	for i := len(backupTiers) - 1; i >= 0 && p.err == nil; i-- {
+		tier := backupTiers[i]
+		keep := interval.Keep(tier)
+		maxAge, err := retention.GetMaxAge(tier)
+		if err != nil {
+			p.err = err
+			return
+		}
+		if keep <= 0 {
+			continue
+		}
+
+		// Sort them by oldest -> newest
+		var backups []string
+		for filename := range p.state[tier] {
+			backups = append(backups, filename)
+		}
+		sortBackups(backups)
+
+		// Slice the items that we need to delete
+		reason := "only keeping " + strconv.Itoa(keep) + " in " + strings.TrimSuffix(tier, "/")
+		totalBackupsToBeDeleted := len(backups) - keep
+		if maxAge > 0 {
+			// The newest N are always kept, even if they're older (i.e. the backups of the target keep failing)
+			reason = "older than " + retention.MaxAge[strings.TrimSuffix(tier, "/")]
+			olderBackups := 0
+			for olderBackups < len(backups) {
+				if createdAt, _ := backupTime(backups[olderBackups]); !createdAt.Before(now.Add(-maxAge)) {
+					break
+				}
+				olderBackups++
+			}
+			totalBackupsToBeDeleted = min(totalBackupsToBeDeleted, olderBackups)
+		}
+
+		for j := 0; j < totalBackupsToBeDeleted && p.err == nil; j++ {
+			p.rotateOut(i, backups[j], reason)
+		}
+	}
+}
+
+// rotateOut removes the backup from the tier, promoting it to the nearest faster tier that symlinks to it.
+func (p *RotationPlanner) rotateOut(tierIndex int, filename string, reason string) {
+	tier := backupTiers[tierIndex]
+	entry := p.state[tier][filename]
+
+	// Check if a faster tier references this file
+	for _, fasterTier := range backupTiers[tierIndex+1:] {
+		symlink, exists := p.state[fasterTier][filename]
+		if !exists {
+			continue
+		}
+		if symlink.LinkTo == "" {
+			p.err = &FileError{"Child folder has a file which should be a symlink, ready for rotate, but isn't: " + fasterTier + filename}
+			return
+		}
+
+		// Move our entry to the place of the symlink, which might be a symlink itself.
+		// This is fine as it's a relative symlink with the same level of depth.
+		p.plan(RotationAction{Type: ActionPromote, Filename: filename, From: tier, To: fasterTier, Reason: reason})
+		delete(p.state[tier], filename)
+		p.state[fasterTier][filename] = entry
+		return
+	}
+
+	// Nothing references this file. Just remove it.
+	p.plan(RotationAction{Type: ActionDelete, Filename: filename, From: tier, LinkTo: entry.LinkTo, Reason: reason})
+	delete(p.state[tier], filename)
+}
+
+// RemoveBackup removes the backup from all tiers, the symlinks before the file.
+func (p *RotationPlanner) RemoveBackup(filename string, reason string) {
+	for i := len(backupTiers) - 1; i >= 0 && p.err == nil; i-- {
+		if entry, exists := p.state[backupTiers[i]][filename]; exists {
+			p.plan(RotationAction{Type: ActionDelete, Filename: filename, From: backupTiers[i], LinkTo: entry.LinkTo, Reason: reason})
+			delete(p.state[backupTiers[i]], filename)
+		}
+	}
+}
+
+// LimitSize removes the oldest backups from all tiers until the total size of the backups fits in the max size.
+// The latest backup is never removed.
+func (p *RotationPlanner) LimitSize(maxSize int64) {
+	if p.err != nil || maxSize <= 0 {
+		return
+	}
+
+	backups := p.state.backups()
+	total := p.state.totalSize()
+	for i := 0; i < len(backups)-1 && total > maxSize; i++ {
+		total -= p.state.size(backups[i])
+		p.RemoveBackup(backups[i], "the backups exceed the max size of "+FormatSize(maxSize))
+	}
+}
+
+// PlanRotation plans adding the new backup (if any) to the project & rotating out the old backups,
+// according to the interval & retention.
+func PlanRotation(state ProjectState, newBackup string, newBackupSize int64, shouldBackup ShouldBackup, interval BackupInterval, retention BackupRetention, now time.Time) ([]RotationAction, error) {
+	planner := NewRotationPlanner(state)
+	if newBackup != "" {
+		planner.AddBackup(newBackup, newBackupSize, shouldBackup)
+	}
+	planner.Purge(interval, retention, now)
+
+	maxSize, err := retention.GetMaxSize()
+	if err != nil {
+		return nil, err
+	}
+	planner.LimitSize(maxSize)
+
+	return planner.Actions()
+}
+
+// PlanQuota plans removing the oldest backups across all projects (project folder => state) until the total size
+// fits in the quota. The latest backup of each project is never removed. Returns the actions per project folder.
+func PlanQuota(projects map[string]ProjectState, quota int64) map[string][]RotationAction {
+	type projectBackup struct {
+		projectFolder string
+		filename      string
+		createdAt     time.Time
+	}
+
+	// Collect all backups that may be removed
+	var total int64
+	var candidates []projectBackup
+	planners := map[string]*RotationPlanner{}
+	for projectFolder, state := range projects {
+		planners[projectFolder] = NewRotationPlanner(state)
+		total += state.totalSize()
+
+		backups := state.backups()
+		for i := 0; i < len(backups)-1; i++ {
+			createdAt, _ := backupTime(backups[i])
+			candidates = append(candidates, projectBackup{projectFolder, backups[i], createdAt})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].createdAt.Equal(candidates[j].createdAt) {
+			return candidates[i].projectFolder+candidates[i].filename < candidates[j].projectFolder+candidates[j].filename
+		}
+		return candidates[i].createdAt.Before(candidates[j].createdAt)
+	})
+
+	// Remove the oldest until everything fits
+	for _, candidate := range candidates {
+		if total <= quota {
+			break
+		}
+
+		planner := planners[candidate.projectFolder]
+		total -= planner.state.size(candidate.filename)
+		planner.RemoveBackup(candidate.filename, "the backup folder exceeds the quota of "+FormatSize(quota))
+	}
+
+	plans := map[string][]RotationAction{}
+	for projectFolder, planner := range planners {
+		if len(planner.actions) > 0 {
+			plans[projectFolder] = planner.actions
+		}
+	}
+
+	return plans
+}
+
+// ApplyRotation executes the planned actions in the project folder & removes the deleted backups from the manifest.
+func ApplyRotation(projectFolder string, actions []RotationAction, logger *log.Logger) error {
+	for _, action := range actions {
+		from := projectFolder + action.From + action.Filename
+		to := projectFolder + action.To + action.Filename
+
+		var err error
+		switch action.Type {
+		case ActionMove:
+			err = os.Rename(from, to)
+		case ActionSymlink:
+			// Relative path = 'Current Subfolder' => 'Go up, back to to rootFolder
+			err = os.Symlink("./../"+action.From+action.Filename, to)
+		case ActionPromote:
+			if err = os.Remove(to); err == nil {
+				err = os.Rename(from, to)
+			}
+			if err == nil {
+				logger.Println("Moved " + from + " to " + to)
+			}
+		case ActionDelete:
+			err = os.Remove(from)
+			if err == nil && action.Reason != "" {
+				logger.Println("Removed " + from + ", " + action.Reason)
+			}
+		default:
+			err = &FileError{"Unknown rotation action: " + string(action.Type)}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Forget the deleted files in the manifest
+	return pruneManifest(projectFolder)
+}
+
+// EnforceQuota removes the oldest backups across all given projects in the backup folder, until the total size of
+// all backups fits in the quota. The latest backup of each project is never removed.
+// Returns the projects that have been changed (also if an error occurred along the way).
+func EnforceQuota(folder string, projects []string, quota int64, logger *log.Logger) (changed []string, err error) {
+	states := map[string]ProjectState{}
+	for _, project := range projects {
+		state, err := ReadProjectState(folder + project + "/")
+		if err != nil {
+			return nil, err
+		}
+		states[folder+project+"/"] = state
+	}
+
+	plans := PlanQuota(states, quota)
+	for _, project := range projects {
+		actions, exists := plans[folder+project+"/"]
+		if !exists {
+			continue
+		}
+
+		changed = append(changed, project)
+		if err := ApplyRotation(folder+project+"/", actions, logger); err != nil {
+			return changed, err
+		}
+	}
+
+	// The planners removed the backups from the states, what's left is still there
+	if total := totalSizeOf(states); total > quota {
+		logger.Println("[warning] The backup folder still exceeds the quota of " + FormatSize(quota) + " with only the latest backups left: " + FormatSize(total))
+	}
+
+	return changed, nil
+}
+
+// totalSizeOf sums the size of the backups of all projects.
+func totalSizeOf(states map[string]ProjectState) (total int64) {
+	for _, state := range states {
+		total += state.totalSize()
+	}
+
+	return total
+}
@@ -0,0 +1,401 @@
+package unitski
+
+import (
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	oldBackup = "site_2025-01-01.sql"
+	newBackup = "site_2026-01-01.sql"
+)
+
+// file is the physical backup file in a tier.
+func file(filename string, size int64) TierEntry {
+	return TierEntry{Filename: filename, Size: size}
+}
+
+// link is a symlink to the same backup in the given (slower) tier.
+func link(filename string, tier string) TierEntry {
+	return TierEntry{Filename: filename, LinkTo: tier}
+}
+
+// filenames returns the sorted filenames in the tier.
+func filenames(state ProjectState, tier string) []string {
+	result := []string{}
+	for filename := range state[tier] {
+		result = append(result, filename)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// dailyBackups returns a tier with a backup (of the given size) for each day in the range.
+func dailyBackups(from string, days int, size int64) map[string]TierEntry {
+	start, _ := time.ParseInLocation(fileDateFormat, from, time.Local)
+	tier := map[string]TierEntry{}
+	for i := 0; i < days; i++ {
+		filename := "site_" + start.AddDate(0, 0, i).Format(fileDateFormat) + ".sql"
+		tier[filename] = file(filename, size)
+	}
+
+	return tier
+}
+
+func TestPlanRotationAddBackup(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.Local)
+	interval := BackupInterval{Hourly: 24, Daily: 7, Weekly: 4, Monthly: 12, Yearly: 1}
+
+	tests := []struct {
+		name     string
+		tiers    []string
+		expected []RotationAction
+	}{
+		{
+			name:  "into all tiers",
+			tiers: []string{yearlyDir, monthlyDir, weeklyDir, dailyDir, hourlyDir},
+			expected: []RotationAction{
+				{Type: ActionMove, Filename: newBackup, To: yearlyDir},
+				{Type: ActionSymlink, Filename: newBackup, From: yearlyDir, To: monthlyDir},
+				{Type: ActionSymlink, Filename: newBackup, From: monthlyDir, To: weeklyDir},
+				{Type: ActionSymlink, Filename: newBackup, From: weeklyDir, To: dailyDir},
+				{Type: ActionSymlink, Filename: newBackup, From: dailyDir, To: hourlyDir},
+			},
+		},
+		{
+			name:  "skipping tiers",
+			tiers: []string{monthlyDir, dailyDir},
+			expected: []RotationAction{
+				{Type: ActionMove, Filename: newBackup, To: monthlyDir},
+				{Type: ActionSymlink, Filename: newBackup, From: monthlyDir, To: dailyDir},
+			},
+		},
+		{
+			name:     "into a single tier",
+			tiers:    []string{dailyDir},
+			expected: []RotationAction{{Type: ActionMove, Filename: newBackup, To: dailyDir}},
+		},
+		{
+			name:  "into no tier",
+			tiers: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			shouldBackup := ShouldBackup{tiers: map[string]bool{}}
+			for _, tier := range test.tiers {
+				shouldBackup.tiers[tier] = true
+			}
+
+			state := ProjectState{}
+			actions, err := PlanRotation(state, newBackup, 100, shouldBackup, interval, BackupRetention{}, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(actions, test.expected) {
+				t.Errorf("actions = %v, expected %v", actions, test.expected)
+			}
+
+			// The file is in the first tier, the other tiers link to the tier before them
+			for i, tier := range test.tiers {
+				expected := file(newBackup, 100)
+				if i > 0 {
+					expected = link(newBackup, test.tiers[i-1])
+				}
+				if entry := state[tier][newBackup]; entry != expected {
+					t.Errorf("%s%s = %+v, expected %+v", tier, newBackup, entry, expected)
+				}
+			}
+		})
+	}
+}
+
+// promotionState has an old & new backup in all tiers, the files in the yearly tier & symlinks in the faster tiers.
+func promotionState() ProjectState {
+	state := ProjectState{
+		yearlyDir: {oldBackup: file(oldBackup, 10), newBackup: file(newBackup, 20)},
+	}
+	for i := 1; i < len(backupTiers); i++ {
+		state[backupTiers[i]] = map[string]TierEntry{
+			oldBackup: link(oldBackup, backupTiers[i-1]),
+			newBackup: link(newBackup, backupTiers[i-1]),
+		}
+	}
+
+	return state
+}
+
+func TestPlanRotationPromotion(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.Local)
+	interval := BackupInterval{Hourly: 24, Daily: 1, Weekly: 1, Monthly: 1, Yearly: 1}
+
+	state := promotionState()
+	actions, err := PlanRotation(state, "", 0, ShouldBackup{}, interval, BackupRetention{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each slower tier replaces the symlink in the hourly tier, which ends up with the file
+	expected := []RotationAction{
+		{Type: ActionPromote, Filename: oldBackup, From: dailyDir, To: hourlyDir, Reason: "only keeping 1 in daily"},
+		{Type: ActionPromote, Filename: oldBackup, From: weeklyDir, To: hourlyDir, Reason: "only keeping 1 in weekly"},
+		{Type: ActionPromote, Filename: oldBackup, From: monthlyDir, To: hourlyDir, Reason: "only keeping 1 in monthly"},
+		{Type: ActionPromote, Filename: oldBackup, From: yearlyDir, To: hourlyDir, Reason: "only keeping 1 in yearly"},
+	}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("actions = %v, expected %v", actions, expected)
+	}
+
+	if entry := state[hourlyDir][oldBackup]; entry != file(oldBackup, 10) {
+		t.Errorf("hourly/%s = %+v, expected the file", oldBackup, entry)
+	}
+	for _, tier := range []string{yearlyDir, monthlyDir, weeklyDir, dailyDir} {
+		if _, exists := state[tier][oldBackup]; exists {
+			t.Errorf("%s%s should have been rotated out", tier, oldBackup)
+		}
+	}
+}
+
+func TestApplyRotationPromotion(t *testing.T) {
+	projectFolder := t.TempDir() + "/"
+	for _, tier := range backupTiers {
+		if err := os.Mkdir(projectFolder+tier, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for filename, entry := range promotionState()[yearlyDir] {
+		if err := os.WriteFile(projectFolder+yearlyDir+filename, []byte(strings.Repeat("x", int(entry.Size))), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i < len(backupTiers); i++ {
+		for _, filename := range []string{oldBackup, newBackup} {
+			if err := os.Symlink("./../"+backupTiers[i-1]+filename, projectFolder+backupTiers[i]+filename); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	state, err := ReadProjectState(projectFolder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state, promotionState()) {
+		t.Fatalf("state = %v, expected %v", state, promotionState())
+	}
+
+	interval := BackupInterval{Hourly: 24, Daily: 1, Weekly: 1, Monthly: 1, Yearly: 1}
+	actions, err := PlanRotation(state, "", 0, ShouldBackup{}, interval, BackupRetention{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyRotation(projectFolder, actions, log.New(io.Discard, "", 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file moved all the way to the hourly tier, the new backup still resolves in every tier
+	if info, err := os.Lstat(projectFolder + hourlyDir + oldBackup); err != nil || !info.Mode().IsRegular() || info.Size() != 10 {
+		t.Errorf("hourly/%s should be the file: %v, %v", oldBackup, info, err)
+	}
+	for _, tier := range backupTiers {
+		if tier != hourlyDir {
+			if _, err := os.Lstat(projectFolder + tier + oldBackup); !os.IsNotExist(err) {
+				t.Errorf("%s%s should have been removed: %v", tier, oldBackup, err)
+			}
+		}
+		if info, err := os.Stat(projectFolder + tier + newBackup); err != nil || info.Size() != 20 {
+			t.Errorf("%s%s should resolve to the file: %v, %v", tier, newBackup, info, err)
+		}
+	}
+}
+
+func TestPlanRotationFileInsteadOfSymlink(t *testing.T) {
+	state := ProjectState{
+		weeklyDir: {oldBackup: file(oldBackup, 10), newBackup: file(newBackup, 10)},
+		dailyDir:  {oldBackup: file(oldBackup, 10)},
+	}
+	interval := BackupInterval{Daily: 7, Weekly: 1}
+
+	_, err := PlanRotation(state, "", 0, ShouldBackup{}, interval, BackupRetention{}, time.Now())
+	if err == nil || !strings.Contains(err.Error(), "should be a symlink") {
+		t.Errorf("expected an error about the file that should be a symlink, got: %v", err)
+	}
+}
+
+func TestPlanRotationMaxAge(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name     string
+		keep     int
+		maxAge   string
+		expected []string
+	}{
+		{
+			name:     "keeps all younger backups",
+			keep:     2,
+			maxAge:   "10d",
+			expected: []string{"site_2026-10-07.sql", "site_2026-10-08.sql", "site_2026-10-09.sql", "site_2026-10-10.sql"},
+		},
+		{
+			name:     "keeps the newest N if fewer are younger",
+			keep:     2,
+			maxAge:   "7d",
+			expected: []string{"site_2026-10-09.sql", "site_2026-10-10.sql"},
+		},
+		{
+			name:     "keeps the newest N if all are older",
+			keep:     3,
+			maxAge:   "1d",
+			expected: []string{"site_2026-10-08.sql", "site_2026-10-09.sql", "site_2026-10-10.sql"},
+		},
+		{
+			name:     "keeps everything if all are younger",
+			keep:     1,
+			maxAge:   "4w",
+			expected: filenames(ProjectState{dailyDir: dailyBackups("2026-10-01", 10, 1)}, dailyDir),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := ProjectState{dailyDir: dailyBackups("2026-10-01", 10, 1)}
+			retention := BackupRetention{MaxAge: map[string]string{"daily": test.maxAge}}
+
+			actions, err := PlanRotation(state, "", 0, ShouldBackup{}, BackupInterval{Daily: test.keep}, retention, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if remaining := filenames(state, dailyDir); !reflect.DeepEqual(remaining, test.expected) {
+				t.Errorf("remaining = %v, expected %v", remaining, test.expected)
+			}
+			for _, action := range actions {
+				if action.Type != ActionDelete || action.Reason != "older than "+test.maxAge {
+					t.Errorf("unexpected action: %v", action)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanRotationMaxSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxSize  string
+		expected []string
+	}{
+		{"fits", "1KB", []string{oldBackup, "site_2025-06-01.sql", newBackup}},
+		{"removes the oldest", "250B", []string{"site_2025-06-01.sql", newBackup}},
+		{"keeps the latest", "1B", []string{newBackup}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := ProjectState{
+				weeklyDir: {
+					oldBackup:             file(oldBackup, 100),
+					"site_2025-06-01.sql": file("site_2025-06-01.sql", 100),
+					newBackup:             file(newBackup, 100),
+				},
+				dailyDir: {
+					oldBackup: link(oldBackup, weeklyDir),
+					newBackup: link(newBackup, weeklyDir),
+				},
+			}
+			interval := BackupInterval{Daily: 7, Weekly: 4}
+
+			actions, err := PlanRotation(state, "", 0, ShouldBackup{}, interval, BackupRetention{MaxSize: test.maxSize}, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if remaining := state.backups(); !reflect.DeepEqual(remaining, test.expected) {
+				t.Errorf("remaining = %v, expected %v", remaining, test.expected)
+			}
+
+			// Whole backups are removed, the symlinks before the file
+			for _, action := range actions {
+				if action.Type != ActionDelete {
+					t.Errorf("unexpected action: %v", action)
+				}
+			}
+			if len(actions) > 0 && (actions[0].Filename != oldBackup || actions[0].From != dailyDir || actions[0].LinkTo != weeklyDir) {
+				t.Errorf("expected the symlink of the oldest backup to be removed first, got: %v", actions[0])
+			}
+		})
+	}
+}
+
+func TestPlanQuota(t *testing.T) {
+	projects := func() map[string]ProjectState {
+		return map[string]ProjectState{
+			"a/": {dailyDir: {
+				"a_2026-10-01.sql": file("a_2026-10-01.sql", 10),
+				"a_2026-10-03.sql": file("a_2026-10-03.sql", 10),
+				"a_2026-10-05.sql": file("a_2026-10-05.sql", 10),
+			}},
+			"b/": {
+				weeklyDir: {"b_2026-10-02.sql": file("b_2026-10-02.sql", 10)},
+				dailyDir: {
+					"b_2026-10-02.sql": link("b_2026-10-02.sql", weeklyDir),
+					"b_2026-10-04.sql": file("b_2026-10-04.sql", 10),
+					"b_2026-10-06.sql": file("b_2026-10-06.sql", 10),
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		quota    int64
+		expected map[string][]string // Project => Removed backups, in order
+	}{
+		{"fits", 60, map[string][]string{}},
+		{
+			name:  "removes the oldest across projects",
+			quota: 30,
+			expected: map[string][]string{
+				"a/": {"a_2026-10-01.sql", "a_2026-10-03.sql"},
+				"b/": {"b_2026-10-02.sql", "b_2026-10-02.sql"},
+			},
+		},
+		{
+			name:  "keeps the latest of each project",
+			quota: 0,
+			expected: map[string][]string{
+				"a/": {"a_2026-10-01.sql", "a_2026-10-03.sql"},
+				"b/": {"b_2026-10-02.sql", "b_2026-10-02.sql", "b_2026-10-04.sql"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			states := projects()
+			plans := PlanQuota(states, test.quota)
+
+			removed := map[string][]string{}
+			for project, actions := range plans {
+				for _, action := range actions {
+					if action.Type != ActionDelete {
+						t.Errorf("unexpected action: %v", action)
+					}
+					removed[project] = append(removed[project], action.Filename)
+				}
+			}
+			if !reflect.DeepEqual(removed, test.expected) {
+				t.Errorf("removed = %v, expected %v", removed, test.expected)
+			}
+			if total := totalSizeOf(states); test.quota > 0 && total > test.quota {
+				t.Errorf("total size %d exceeds the quota %d", total, test.quota)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package unitski
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var weekdayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	"sunday": 0, "monday": 1, "tuesday": 2, "wednesday": 3, "thursday": 4, "friday": 5, "saturday": 6,
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+// DayOfMonth is a day of the month (1-31) or "last", it can be written as a JSON number or string.
+type DayOfMonth string
+
+func (d *DayOfMonth) UnmarshalJSON(data []byte) error {
+	var number int
+	if err := json.Unmarshal(data, &number); err == nil {
+		*d = DayOfMonth(strconv.Itoa(number))
+		return nil
+	}
+
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*d = DayOfMonth(value)
+	return nil
+}
+
+// Schedule decides on which days (& hours) a backup should be made, parsed from a cron-like
+// "[hour] day-of-month month day-of-week" expression, the hour is optional & defaults to every hour.
+// Each field is a '*' or a list of values, ranges & steps (i.e. '1,15', 'mon-fri', '*/2').
+// The day of month also accepts 'L' for the last day, days beyond the end of a month fall on its last day.
+// Like cron, a day matches either of the two day fields if both are restricted.
+type Schedule struct {
+	expression string
+	hour       uint64
+	dom        uint64
+	domLast    bool
+	month      uint64
+	dow        uint64
+	domAny     bool
+	dowAny     bool
+}
+
+// ParseSchedule parses the cron-like expression.
+func ParseSchedule(expression string) (*Schedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) == 3 {
+		fields = append([]string{"*"}, fields...)
+	}
+	if len(fields) != 4 {
+		return nil, &FileError{"Invalid schedule '" + expression + "', expected '[hour] day-of-month month day-of-week'"}
+	}
+
+	schedule := &Schedule{expression: expression, domAny: fields[1] == "*", dowAny: fields[3] == "*"}
+	var err error
+
+	// => Hour
+	if schedule.hour, err = parseScheduleField(fields[0], 0, 23, nil); err != nil {
+		return nil, &FileError{"Invalid hour in schedule '" + expression + "': " + err.Error()}
+	}
+
+	// => Day of month, with support for the last day
+	var days []string
+	for _, item := range strings.Split(fields[1], ",") {
+		if strings.EqualFold(item, "L") {
+			schedule.domLast = true
+		} else {
+			days = append(days, item)
+		}
+	}
+	if len(days) > 0 {
+		if schedule.dom, err = parseScheduleField(strings.Join(days, ","), 1, 31, nil); err != nil {
+			return nil, &FileError{"Invalid day of month in schedule '" + expression + "': " + err.Error()}
+		}
+	}
+
+	// => Month & day of week (7 is also sunday)
+	if schedule.month, err = parseScheduleField(fields[2], 1, 12, monthNames); err != nil {
+		return nil, &FileError{"Invalid month in schedule '" + expression + "': " + err.Error()}
+	}
+	if schedule.dow, err = parseScheduleField(fields[3], 0, 7, weekdayNames); err != nil {
+		return nil, &FileError{"Invalid day of week in schedule '" + expression + "': " + err.Error()}
+	}
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+
+	return schedule, nil
+}
+
+// Matches checks whether a backup should be made at the given time.
+func (s *Schedule) Matches(t time.Time) bool {
+	if s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	// Days beyond the end of the month fall on the last day
+	lastDay := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	domMatches := s.dom&(1<<uint(t.Day())) != 0 || (t.Day() == lastDay && (s.domLast || s.dom>>uint(lastDay+1) != 0))
+	dowMatches := s.dow&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dowMatches
+	case s.dowAny:
+		return domMatches
+	default:
+		return domMatches || dowMatches
+	}
+}
+
+func (s *Schedule) String() string {
+	return s.expression
+}
+
+// parseScheduleField parses a comma separated list of '*', values, ranges & steps into a bit set.
+func parseScheduleField(field string, min int, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		// => Step
+		step := 1
+		if index := strings.Index(item, "/"); index >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[index+1:]); err != nil || step <= 0 {
+				return 0, &FileError{"invalid step '" + item + "'"}
+			}
+			item = item[:index]
+		}
+
+		// => Range
+		from, to := min, max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if from, err = parseScheduleValue(bounds[0], min, max, names); err != nil {
+				return 0, err
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = parseScheduleValue(bounds[1], min, max, names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				to = max
+			}
+			if to < from {
+				return 0, &FileError{"invalid range '" + item + "'"}
+			}
+		}
+
+		for value := from; value <= to; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+func parseScheduleValue(value string, min int, max int, names map[string]int) (int, error) {
+	if named, known := names[strings.ToLower(value)]; known {
+		return named, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min || number > max {
+		return 0, &FileError{"invalid value '" + value + "'"}
+	}
+
+	return number, nil
+}
+
+// GetSchedule returns the schedule of the backups in the tier folder, the expression in the config overrides the default.
+// Weekly backups are made on monday, monthly backups on the 1st & yearly backups on the 1st of january,
+// unless configured otherwise. Hourly & daily backups are made on every run.
+func (i BackupInterval) GetSchedule(tier string) (*Schedule, error) {
+	if expression := i.Schedule[strings.TrimSuffix(tier, "/")]; expression != "" {
+		return ParseSchedule(expression)
+	}
+
+	switch tier {
+	case weeklyDir:
+		weekday := "mon"
+		if i.WeeklyOn != "" {
+			weekday = i.WeeklyOn
+		}
+		return ParseSchedule("* * " + weekday)
+	case monthlyDir:
+		day := "1"
+		if strings.EqualFold(string(i.MonthlyOn), "last") {
+			day = "L"
+		} else if i.MonthlyOn != "" {
+			day = string(i.MonthlyOn)
+		}
+		return ParseSchedule(day + " * *")
+	case yearlyDir:
+		return ParseSchedule("1 jan *")
+	default:
+		return ParseSchedule("* * *")
+	}
+}
@@ -0,0 +1,138 @@
+package unitski
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleMatches(t *testing.T) {
+	at := func(date string, hour int) time.Time {
+		day, err := time.Parse(fileDateFormat, date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return day.Add(time.Duration(hour) * time.Hour)
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		time       time.Time
+		matches    bool
+	}{
+		// Days beyond the end of the month fall on its last day
+		{"31st in january", "31 * *", at("2026-01-31", 0), true},
+		{"31st not on the 30th of january", "31 * *", at("2026-01-30", 0), false},
+		{"31st in april", "31 * *", at("2026-04-30", 0), true},
+		{"31st in february", "31 * *", at("2026-02-28", 0), true},
+		{"30th in a leap year february", "30 * *", at("2024-02-29", 0), true},
+		{"30th not before the end of february", "30 * *", at("2024-02-28", 0), false},
+		{"1st & 31st on the 1st", "1,31 * *", at("2026-02-01", 0), true},
+
+		// Last day of the month
+		{"last day", "L * *", at("2026-01-31", 0), true},
+		{"not the last day", "L * *", at("2026-01-30", 0), false},
+		{"last day of february", "l * *", at("2026-02-28", 0), true},
+		{"last day of a quarter", "L mar,jun,sep,dec *", at("2026-06-30", 0), true},
+		{"last day outside a quarter end", "L mar,jun,sep,dec *", at("2026-01-31", 0), false},
+		{"last day or the 15th", "15,L * *", at("2026-03-15", 0), true},
+
+		// Either day field matches if both are restricted
+		{"day of month & weekday, both", "1 * mon", at("2026-06-01", 0), true},
+		{"day of month & weekday, only the day", "1 * mon", at("2026-10-01", 0), true},
+		{"day of month & weekday, only the weekday", "1 * mon", at("2026-10-05", 0), true},
+		{"day of month & weekday, neither", "1 * mon", at("2026-10-06", 0), false},
+		{"only the weekday restricted", "* * mon", at("2026-10-01", 0), false},
+		{"only the day restricted", "1 * *", at("2026-10-05", 0), false},
+		{"month limits both day fields", "1 jan mon", at("2026-06-01", 0), false},
+
+		// Sunday is 0 & 7
+		{"7 is sunday", "* * 7", at("2026-10-18", 0), true},
+		{"7 isn't saturday", "* * 7", at("2026-10-17", 0), false},
+		{"0 is sunday", "* * 0", at("2026-10-18", 0), true},
+		{"range up to 7", "* * 5-7", at("2026-10-18", 0), true},
+
+		// Steps & (named) ranges
+		{"every 4 hours", "*/4 * * *", at("2026-10-16", 8), true},
+		{"every 4 hours, off hour", "*/4 * * *", at("2026-10-16", 5), false},
+		{"hour defaults to every hour", "* * *", at("2026-10-16", 13), true},
+		{"stepped range", "1-10/3 * *", at("2026-10-07", 0), true},
+		{"stepped range, off day", "1-10/3 * *", at("2026-10-08", 0), false},
+		{"stepped range, beyond the end", "1-10/3 * *", at("2026-10-13", 0), false},
+		{"step from a value", "20/5 * *", at("2026-10-30", 0), true},
+		{"named month range", "* jan-mar *", at("2026-02-10", 0), true},
+		{"named month range, outside", "* jan-mar *", at("2026-04-10", 0), false},
+		{"named weekday range", "* * mon-fri", at("2026-10-14", 0), true},
+		{"named weekday range, weekend", "* * mon-fri", at("2026-10-17", 0), false},
+		{"full weekday names", "* * Saturday,sunday", at("2026-10-17", 0), true},
+		{"hour list", "2,14 * * *", at("2026-10-16", 14), true},
+		{"hour list, off hour", "2,14 * * *", at("2026-10-16", 0), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(test.expression)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q): %v", test.expression, err)
+			}
+			if matches := schedule.Matches(test.time); matches != test.matches {
+				t.Errorf("%q matches %v = %v, expected %v", test.expression, test.time, matches, test.matches)
+			}
+		})
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"* *",
+		"* * * * *",
+		"32 * *",
+		"0 * *",
+		"* 13 *",
+		"* * 8",
+		"24 * * *",
+		"* * funday",
+		"* * mon-sun",
+		"10-5 * *",
+		"*/0 * *",
+		"*/x * *",
+		"L-5 * *",
+	} {
+		if _, err := ParseSchedule(expression); err == nil {
+			t.Errorf("ParseSchedule(%q) should fail", expression)
+		}
+	}
+}
+
+func TestGetScheduleDefaults(t *testing.T) {
+	monday := time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		interval BackupInterval
+		tier     string
+		time     time.Time
+		matches  bool
+	}{
+		{"weekly on monday", BackupInterval{}, weeklyDir, monday, true},
+		{"weekly not on tuesday", BackupInterval{}, weeklyDir, monday.AddDate(0, 0, 1), false},
+		{"weekly on friday", BackupInterval{WeeklyOn: "friday"}, weeklyDir, monday.AddDate(0, 0, 4), true},
+		{"monthly on the 1st", BackupInterval{}, monthlyDir, monday.AddDate(0, 0, -4), true},
+		{"monthly on the last day", BackupInterval{MonthlyOn: "last"}, monthlyDir, monday.AddDate(0, 0, 26), true},
+		{"yearly on the 1st of january", BackupInterval{}, yearlyDir, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"schedule overrides weekly-on", BackupInterval{WeeklyOn: "friday", Schedule: map[string]string{"weekly": "* * mon"}}, weeklyDir, monday, true},
+		{"daily on every day", BackupInterval{}, dailyDir, monday.AddDate(0, 0, 3), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := test.interval.GetSchedule(test.tier)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matches := schedule.Matches(test.time); matches != test.matches {
+				t.Errorf("%s (%s) matches %v = %v, expected %v", test.tier, schedule, test.time, matches, test.matches)
+			}
+		})
+	}
+}
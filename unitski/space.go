@@ -0,0 +1,132 @@
+package unitski
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// defaultMinFreeSpace is used when no 'min-free-space' is configured
+const defaultMinFreeSpace = "5GB"
+
+type LowSpacePolicy string
+
+const (
+	LowSpaceSkip LowSpacePolicy = "skip" // Skip the backup, only logged as info
+	LowSpaceWarn LowSpacePolicy = "warn" // Log a warning, but still try to make the backup
+	LowSpaceFail LowSpacePolicy = "fail" // Don't make the backup & report it as an error (default)
+)
+
+var sizeUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"KB":  1_000,
+	"MB":  1_000_000,
+	"GB":  1_000_000_000,
+	"TB":  1_000_000_000_000,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+}
+
+// MinFreeSpace is the amount of space that should always remain free on the backup disk.
+// Either an absolute number of bytes or a percentage of the total size of the disk.
+type MinFreeSpace struct {
+	Bytes      int64
+	Percentage float64
+}
+
+// ParseSize parses a size like `500MB`, `1.5 GB` or `2GiB` into a number of bytes.
+func ParseSize(value string) (int64, error) {
+	match := regexp.MustCompile("^\\s*(\\d+(?:\\.\\d+)?)\\s*([a-zA-Z]*)\\s*$").FindStringSubmatch(value)
+	if match == nil {
+		return 0, &FileError{"Invalid size: " + value}
+	}
+
+	unit, ok := sizeUnits[strings.ToUpper(match[2])]
+	if !ok {
+		return 0, &FileError{"Unknown size unit in: " + value}
+	}
+	number, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(number * float64(unit)), nil
+}
+
+// FormatSize formats the number of bytes in a human-readable way.
+func FormatSize(bytes int64) string {
+	for _, unit := range []string{"TB", "GB", "MB", "KB"} {
+		if size := sizeUnits[unit]; bytes >= size {
+			return fmt.Sprintf("%.1f%v", float64(bytes)/float64(size), unit)
+		}
+	}
+
+	return strconv.FormatInt(bytes, 10) + "B"
+}
+
+// ParseMinFreeSpace parses the 'min-free-space' setting, either a size (i.e. `5GB`) or a percentage (i.e. `10%`).
+func ParseMinFreeSpace(value string) (MinFreeSpace, error) {
+	if value == "" {
+		value = defaultMinFreeSpace
+	}
+
+	if strings.HasSuffix(value, "%") {
+		percentage, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(value, "%")), 64)
+		if err != nil || percentage < 0 || percentage >= 100 {
+			return MinFreeSpace{}, &FileError{"Invalid min free space percentage: " + value}
+		}
+		return MinFreeSpace{Percentage: percentage}, nil
+	}
+
+	bytes, err := ParseSize(value)
+	return MinFreeSpace{Bytes: bytes}, err
+}
+
+// NotEnoughSpaceError is returned when a backup wouldn't fit on the backup disk.
+type NotEnoughSpaceError struct {
+	Required  int64
+	Available int64
+}
+
+func (error *NotEnoughSpaceError) Error() string {
+	return "Not enough disk space available, requires ~" + FormatSize(error.Required) + " but only " + FormatSize(error.Available) + " is available"
+}
+
+// GetDiskSpaceAvailable resolves the disk where the given folder is on and returns the number of bytes available on that disk,
+// minus the space that should always remain free.
+func GetDiskSpaceAvailable(folder string, minFreeSpace MinFreeSpace) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(folder, &stat); err != nil {
+		return 0, err
+	}
+
+	// Blocks available to non-root users * size of a block
+	available := int64(stat.Bavail) * int64(stat.Bsize)
+	total := int64(stat.Blocks) * int64(stat.Bsize)
+
+	return available - minFreeSpace.Bytes - int64(float64(total)*minFreeSpace.Percentage/100), nil
+}
+
+// CheckDiskSpace checks whether the required number of bytes fit in the given folder, returns a NotEnoughSpaceError if not.
+// The reserved bytes are claimed by other (running) backups but not written yet, so they aren't available either.
+func CheckDiskSpace(folder string, required int64, reserved int64, minFreeSpace MinFreeSpace) error {
+	available, err := GetDiskSpaceAvailable(folder, minFreeSpace)
+	if err != nil {
+		return err
+	}
+	available -= reserved
+
+	if required > available {
+		if available < 0 {
+			available = 0
+		}
+		return &NotEnoughSpaceError{required, available}
+	}
+
+	return nil
+}
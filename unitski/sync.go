@@ -0,0 +1,194 @@
+package unitski
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SyncProjectFolder mirrors the given project folder (including the symlinks between the tiers) to the sync folder.
+// Files that have been rotated out are removed from the mirror, unless they're monthly (or yearly) backups & rotateMonthly is false.
+func SyncProjectFolder(projectFolder string, syncFolder string, rotateMonthly bool, logger *log.Logger) error {
+	source := filepath.Clean(projectFolder)
+	target := filepath.Join(syncFolder, filepath.Base(source))
+
+	manifest, err := LoadManifest(source)
+	if err != nil {
+		return err
+	}
+	mirrorManifest, err := LoadManifest(target)
+	if err != nil {
+		return err
+	}
+
+	// Copy everything that is new or changed to the mirror
+	var copied []string
+	err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+
+		// The manifest of the mirror is merged instead, it might have backups that are kept on the mirror only
+		if relativePath == manifestFile {
+			return nil
+		}
+
+		copiedFile, err := mirrorEntry(path, filepath.Join(target, relativePath), info)
+		if copiedFile {
+			copied = append(copied, relativePath)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Make sure the copies match the manifest
+	for _, relativePath := range copied {
+		if entry, known := manifest.Files[filepath.Base(relativePath)]; known {
+			if err := entry.Check(filepath.Join(target, relativePath)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Remove everything from the mirror that no longer exists in the project folder
+	var toRemove []string
+	err = filepath.Walk(target, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(target, path)
+		if err != nil {
+			return err
+		}
+
+		// Monthly (& yearly) backups might be kept forever on the mirror
+		if !rotateMonthly && (strings.HasPrefix(relativePath+"/", monthlyDir) || strings.HasPrefix(relativePath+"/", yearlyDir)) {
+			return nil
+		}
+
+		if _, err := os.Lstat(filepath.Join(source, relativePath)); os.IsNotExist(err) {
+			toRemove = append(toRemove, path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		} else if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range toRemove {
+		logger.Println("Removing rotated out file from sync folder: " + path)
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	// Merge the manifests & forget the backups that are no longer on the mirror
+	for filename, entry := range manifest.Files {
+		mirrorManifest.Files[filename] = entry
+	}
+	if err := mirrorManifest.Save(target); err != nil {
+		return err
+	}
+
+	return pruneManifest(target + "/")
+}
+
+// mirrorEntry makes sure the target is an exact copy of the given source entry (folder, symlink or file).
+// Returns whether the (changed) file had to be copied.
+func mirrorEntry(source string, target string, info os.FileInfo) (bool, error) {
+	targetInfo, err := os.Lstat(target)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	exists := err == nil
+
+	// Remove the target if it's of a different type (i.e. a symlink that has been replaced by a rotated file)
+	if exists && targetInfo.Mode().Type() != info.Mode().Type() {
+		if err := os.RemoveAll(target); err != nil {
+			return false, err
+		}
+		exists = false
+	}
+
+	switch {
+	case info.IsDir():
+		if !exists {
+			return false, os.Mkdir(target, info.Mode().Perm())
+		}
+		return false, nil
+
+	case info.Mode()&os.ModeSymlink == os.ModeSymlink:
+		link, err := os.Readlink(source)
+		if err != nil {
+			return false, err
+		}
+		if exists {
+			if currentLink, err := os.Readlink(target); err == nil && currentLink == link {
+				return false, nil
+			}
+			if err := os.Remove(target); err != nil {
+				return false, err
+			}
+		}
+		return false, os.Symlink(link, target)
+
+	case info.Mode().IsRegular():
+		if exists && targetInfo.Size() == info.Size() && targetInfo.ModTime().Equal(info.ModTime()) {
+			return false, nil
+		}
+		return true, copyFile(source, target, info)
+
+	default:
+		return false, &FileError{"Unable to sync, not a regular file: " + source}
+	}
+}
+
+// copyFile copies the file to a temporary file next to the target, which is then moved into place.
+// Keeps the permissions & modification time of the source, so unchanged files can be skipped next time.
+func copyFile(source string, target string, info os.FileInfo) (err error) {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmpFile := target + ".sync-tmp"
+	out, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpFile)
+		}
+	}()
+
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Chtimes(tmpFile, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, target)
+}
@@ -0,0 +1,173 @@
+package unitski
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"io"
+	"log"
+	"strconv"
+	"time"
+)
+
+const testRestoreLabel = "unitski-backup.test-restore"
+const testRestoreStartTimeout = 3 * time.Minute
+const defaultTestRestoreQuery = "SHOW TABLES"
+
+// TestRestoreDatabase loads the backup into a throwaway container & runs the sanity query of the config against it.
+// The container is removed afterwards, whatever the outcome. Returns the output of the query.
+// The query fails the test if it errors or returns nothing (or a count of 0).
+// The identity is only required if the backup is encrypted.
+func TestRestoreDatabase(
+	cli *client.Client,
+	ctx context.Context,
+	config BackupConfigDatabase,
+	backupFile string,
+	identity *Identity,
+	logger *log.Logger,
+) (string, error) {
+	if config.Type != "" && config.Type != DbTypeMySql {
+		return "", &DockerError{"Test restores are only supported for MySQL/MariaDB databases"}
+	}
+
+	// Determine the image & database name from the database container, it doesn't have to be running
+	image, database := config.TestRestore.Image, config.Database.Value
+	if image == "" || config.Database.VarType == VarTypeDockerEnv {
+		original, err := cli.ContainerInspect(ctx, config.Container)
+		if err != nil {
+			return "", err
+		}
+		if image == "" {
+			image = original.Config.Image
+		}
+		if database, err = ResolveContainerVariable(original, "", config.Database); err != nil {
+			return "", err
+		}
+	}
+
+	// Start the throwaway container
+	password, err := randomPassword()
+	if err != nil {
+		return "", err
+	}
+	containerId, err := startTestContainer(cli, ctx, config, image, password, logger)
+	if containerId != "" {
+		defer func() {
+			removeErr := cli.ContainerRemove(context.Background(), containerId, types.ContainerRemoveOptions{
+				RemoveVolumes: true,
+				Force:         true,
+			})
+			if removeErr != nil {
+				logger.Println("[warning] Failed to remove test container " + containerId + ": " + removeErr.Error())
+			}
+		}()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Load the backup into it, as root
+	testConfig := config
+	testConfig.Container = containerId
+	testConfig.User = BackupVariable{VarTypeConstant, "root"}
+	testConfig.Password = BackupVariable{VarTypeConstant, password}
+	testConfig.Database = BackupVariable{VarTypeConstant, database}
+	logger.Println("[info] Loading " + backupFile + " into the test container")
+	if err := RestoreDatabase(cli, ctx, testConfig, backupFile, "", identity, logger); err != nil {
+		return "", err
+	}
+
+	// Run the sanity query
+	query := config.TestRestore.Query
+	if query == "" {
+		query = defaultTestRestoreQuery
+	}
+	credentials := databaseCredentials{user: "root", password: password, database: database}
+	output, err := execInContainer(cli, ctx, logger, containerId, credentials.mySqlEnv(), []string{
+		"mysql", "-u", credentials.user, "--batch", "--skip-column-names", "-e", query, credentials.database,
+	})
+	if err != nil {
+		return "", err
+	}
+	if output == "" {
+		return output, &DockerError{"Sanity query '" + query + "' returned nothing"}
+	} else if output == "0" {
+		return output, &DockerError{"Sanity query '" + query + "' returned 0"}
+	}
+
+	return output, nil
+}
+
+// startTestContainer creates & starts a container of the image, waiting until MySQL accepts connections.
+// Returns the ID of the container as soon as it's created, even if it fails to start.
+func startTestContainer(cli *client.Client, ctx context.Context, config BackupConfigDatabase, image string, password string, logger *log.Logger) (string, error) {
+	// Pull the image if it isn't available yet
+	if _, _, err := cli.ImageInspectWithRaw(ctx, image); client.IsErrNotFound(err) {
+		logger.Println("[info] Pulling image " + image)
+		progress, err := cli.ImagePull(ctx, image, types.ImagePullOptions{})
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(io.Discard, progress)
+		_ = progress.Close()
+		if err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	logger.Println("[info] Starting test container of image " + image)
+	created, err := cli.ContainerCreate(ctx, &container.Config{
+		Image: image,
+		Env: []string{
+			"MYSQL_ROOT_PASSWORD=" + password,
+			"MARIADB_ROOT_PASSWORD=" + password,
+		},
+		Labels: map[string]string{testRestoreLabel: config.Name},
+	}, &container.HostConfig{}, nil, nil, "")
+	if err != nil {
+		return "", err
+	}
+	if err := cli.ContainerStart(ctx, created.ID, types.ContainerStartOptions{}); err != nil {
+		return created.ID, err
+	}
+
+	// The entrypoint initializes the database with a temporary server without networking first,
+	// so MySQL is only ready once it accepts TCP connections.
+	quiet := log.New(io.Discard, "", 0)
+	env := map[string]string{"MYSQL_PWD": password}
+	deadline := time.Now().Add(testRestoreStartTimeout)
+	for {
+		_, err := execInContainer(cli, ctx, quiet, created.ID, env, []string{
+			"mysql", "-h", "127.0.0.1", "-u", "root", "-e", "SELECT 1",
+		})
+		if err == nil {
+			return created.ID, nil
+		}
+
+		if state, inspectErr := cli.ContainerInspect(ctx, created.ID); inspectErr != nil {
+			return created.ID, inspectErr
+		} else if !state.State.Running {
+			return created.ID, &DockerError{"Test container stopped while starting (exit code " + strconv.Itoa(state.State.ExitCode) + ")"}
+		}
+		if time.Now().After(deadline) {
+			return created.ID, &DockerError{"Test container didn't accept connections within " + testRestoreStartTimeout.String()}
+		}
+
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// randomPassword generates a password for the root user of a test container.
+func randomPassword() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
@@ -0,0 +1,137 @@
+package unitski
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// trailerWindow is the number of bytes at the end of a dump that are searched for the trailer.
+const trailerWindow = 1024
+
+// VerifyProjectFolder checks the tiers of the project folder & every backup in them.
+// The trailer is the text every backup should end with (see DumpTrailer), nothing is checked if it's empty.
+// Without an identity only the checksums of encrypted backups are checked, not their content.
+// Returns all problems that were found, the error is only set if the folder itself can't be read.
+func VerifyProjectFolder(projectFolder string, trailer string, identity *Identity) (problems []string, err error) {
+	regex := regexp.MustCompile(fileDatePattern)
+
+	// => Leftovers of backups that failed halfway
+	entries, err := os.ReadDir(projectFolder)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), partialSuffix) {
+			problems = append(problems, "Unfinished backup: "+projectFolder+entry.Name())
+		}
+	}
+
+	// => Files in the tiers that aren't backups or are symlinks to nothing
+	for _, tier := range backupTiers {
+		entries, err := os.ReadDir(projectFolder + tier)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return problems, err
+		}
+
+		for _, entry := range entries {
+			path := projectFolder + tier + entry.Name()
+			if !regex.MatchString(entry.Name()) {
+				problems = append(problems, "Not a backup (name doesn't contain a date): "+path)
+			} else if entry.Type()&os.ModeSymlink != 0 {
+				if _, err := os.Stat(path); err != nil {
+					problems = append(problems, "Dangling symlink: "+path)
+				}
+			}
+		}
+	}
+
+	// => The content of the backups, compared to the manifest (backups made before the manifest existed are skipped)
+	manifest, err := LoadManifest(projectFolder)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	backups, err := FindBackups(projectFolder)
+	if err != nil {
+		return problems, err
+	}
+	for _, backup := range backups {
+		if backup.Path == "" {
+			continue
+		}
+		if entry, known := manifest.Files[backup.Filename]; known {
+			if err := entry.Check(backup.Path); err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+		}
+		if identity == nil && IsEncrypted(backup.Path) {
+			continue
+		}
+		if err := VerifyBackup(backup.Path, trailer, identity); err != nil {
+			problems = append(problems, "Corrupt backup "+backup.Path+": "+err.Error())
+		}
+	}
+
+	return problems, nil
+}
+
+// VerifyBackup reads the whole backup, checking the integrity of the compression & the tar ball (if it is one).
+// The decompressed content should end with the trailer, unless it's empty or the backup is a tar ball.
+func VerifyBackup(path string, trailer string, identity *Identity) error {
+	backup, err := OpenArtifact(path, identity)
+	if err != nil {
+		return err
+	}
+	defer backup.Close()
+
+	// Read every entry of tar balls
+	if strings.Contains(filepath.Base(path), ".tar") {
+		reader := tar.NewReader(backup)
+		for {
+			if _, err := reader.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				return &FileError{"Unreadable tar ball: " + err.Error()}
+			}
+			if _, err := io.Copy(io.Discard, reader); err != nil {
+				return &FileError{"Unreadable tar ball: " + err.Error()}
+			}
+		}
+
+		// Anything after the end of the archive still needs to pass the decompression checks
+		_, err := io.Copy(io.Discard, backup)
+		return err
+	}
+
+	// Read the whole dump, keeping the tail
+	tail := tailWriter{}
+	if _, err := io.Copy(&tail, backup); err != nil {
+		return err
+	}
+	if trailer != "" && !bytes.Contains(tail.buffer, []byte(trailer)) {
+		return &FileError{"Incomplete dump, it doesn't end with '" + strings.TrimSpace(trailer) + "'"}
+	}
+
+	return nil
+}
+
+// tailWriter only keeps the last bytes that are written to it.
+type tailWriter struct {
+	buffer []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.buffer = append(w.buffer, p...)
+	if len(w.buffer) > trailerWindow {
+		w.buffer = w.buffer[len(w.buffer)-trailerWindow:]
+	}
+
+	return len(p), nil
+}